@@ -6,7 +6,7 @@ require (
 	github.com/bwmarrin/discordgo v0.23.2
 	github.com/dpatterbee/bpipe v0.1.0
 	github.com/jonas747/dca v0.0.0-20201113050843-65838623978b
-	github.com/jonas747/ogg v0.0.0-20161220051205-b4f6f4cf3757 // indirect
+	github.com/jonas747/ogg v0.0.0-20161220051205-b4f6f4cf3757
 	github.com/kkdai/youtube/v2 v2.7.2
 	github.com/mattn/go-sqlite3 v1.14.7
 	github.com/rs/zerolog v1.23.0
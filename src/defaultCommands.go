@@ -1,12 +1,16 @@
 package strife
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	dgo "github.com/bwmarrin/discordgo"
 	"github.com/dpatterbee/strife/src/media"
+	"github.com/dpatterbee/strife/src/store"
 )
 
 type botCommand struct {
@@ -59,6 +63,9 @@ var something = []botCommand{
 	{
 		command: "play", function: playSound, permission: botunknown,
 	},
+	{
+		command: "search", function: searchSound, permission: botunknown,
+	},
 	{
 		command: "pause", function: pauseSound, permission: botunknown,
 	},
@@ -68,12 +75,92 @@ var something = []botCommand{
 	{
 		command: "skip", function: skipSound, permission: botunknown,
 	},
+	{
+		command: "voteskip", function: voteSkipSettings, permission: botmoderator,
+	},
+	{
+		command: "volume", function: setVolume, permission: botdj,
+	},
+	{
+		command: "normalize", function: setNormalize, permission: botdj,
+	},
+	{
+		command: "filter", function: setFilter, permission: botdj,
+	},
+	{
+		command: "timeout", function: idleTimeouts, permission: botmoderator,
+	},
+	{
+		command: "seek", function: seekSound, permission: botunknown,
+	},
+	{
+		command: "forward", function: forwardSound, permission: botunknown,
+	},
+	{
+		command: "rewind", function: rewindSound, permission: botunknown,
+	},
+	{
+		command: "summon", function: summonPlayer, permission: botunknown,
+		aliases: []string{"join"},
+	},
 	{
 		command: "disconnect", function: disconnectVoice, permission: botunknown,
 	},
 	{
 		command: "queue", function: inspectQueue, permission: botunknown,
 	},
+	{
+		command: "nowplaying", function: nowPlaying, permission: botunknown,
+		aliases: []string{"np"},
+	},
+	{
+		command: "remove", function: removeSong, permission: botunknown,
+	},
+	{
+		command: "move", function: moveSong, permission: botunknown,
+	},
+	{
+		command: "shuffle", function: shuffleQueue, permission: botunknown,
+	},
+	{
+		command: "clear", function: clearQueue, permission: botunknown,
+	},
+	{
+		command: "loop", function: loopQueue, permission: botunknown,
+	},
+	{
+		command: "restore", function: restoreQueue, permission: botunknown,
+	},
+	{
+		command: "playlist", function: playlistCommand, permission: botunknown,
+	},
+	{
+		command: "sound", function: soundCommand, permission: botunknown,
+	},
+	{
+		command: "sb", function: playSoundboard, permission: botunknown,
+	},
+	{
+		command: "say", function: saySpeech, permission: botunknown,
+	},
+	{
+		command: "announce", function: announceSongs, permission: botmoderator,
+	},
+	{
+		command: "record", function: recordCommand, permission: botmoderator,
+	},
+	{
+		command: "policy", function: queuePolicy, permission: botmoderator,
+	},
+	{
+		command: "history", function: showHistory, permission: botunknown,
+	},
+	{
+		command: "replay", function: replaySound, permission: botunknown,
+	},
+	{
+		command: "stats", function: showStats, permission: botunknown,
+	},
 }
 
 func makeDefaultCommands() map[string]botCommand {
@@ -258,38 +345,421 @@ func getUserVoiceChannel(userID, guildID string) (string, error) {
 
 }
 
-func mediaCommand(userID, guildID string, k media.Action, data string) (string, error) {
+func mediaCommand(s *dgo.Session, m *dgo.MessageCreate, k media.Action, data string) (string, error) {
+	response, err := mediaRequest(s, m, k, data)
+	if err != nil {
+		return "", err
+	}
+	return responseMessage(response), nil
+}
+
+// responseMessage returns the text to show the user for a response from the media controller.
+func responseMessage(r media.Response) string {
+	if r.Status == media.StatusTimeout {
+		return "The player hasn't replied yet, your request may still be carried out."
+	}
+	return r.Message
+}
+
+// mediaRequest sends a request for the author of m to the media controller from the voice channel
+// they are in, returning the controller's response.
+func mediaRequest(s *dgo.Session, m *dgo.MessageCreate, k media.Action,
+	data string) (media.Response, error) {
 
-	userVoiceChannel, err := getUserVoiceChannel(userID, guildID)
+	userVoiceChannel, err := getUserVoiceChannel(m.Author.ID, m.GuildID)
 	if err != nil {
-		return fmt.Sprintf("You must be in a voice channel to %v the song",
-			strings.ToLower(k.String())), nil
+		return media.Response{
+			Status: media.StatusRejected,
+			Err:    media.ErrNotInChannel,
+			Message: fmt.Sprintf("You must be in a voice channel to %v the song",
+				strings.ToLower(k.String())),
+		}, nil
+	}
+
+	user := media.User{
+		ID:         m.Author.ID,
+		Privileged: userPermissionLevel(s, m) >= botdj,
 	}
 
-	return bot.mediaController.Send(guildID, userVoiceChannel, k, data)
+	return requestMedia(m.GuildID, userVoiceChannel, user, k, data)
 
 }
 
-func playSound(_ *dgo.Session, m *dgo.MessageCreate, s string) (string, error) {
-	return mediaCommand(m.Author.ID, m.GuildID, media.PLAY, s)
+// mediaTimeout is how long commands wait for a reply from the media controller.
+const mediaTimeout = 5 * time.Second
+
+// sendMedia sends a request to the media controller, returning the reply to show the user.
+func sendMedia(guildID, channelID string, user media.User, k media.Action,
+	data string) (string, error) {
+
+	response, err := requestMedia(guildID, channelID, user, k, data)
+	if err != nil {
+		return "", err
+	}
+	return responseMessage(response), nil
 }
 
-func pauseSound(_ *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
-	return mediaCommand(m.Author.ID, m.GuildID, media.PAUSE, "")
+// requestMedia sends a request to the media controller, returning its response.
+func requestMedia(guildID, channelID string, user media.User, k media.Action,
+	data string) (media.Response, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+
+	return bot.mediaController.Send(ctx, guildID, channelID, user, k, data)
 }
 
-func resumeSound(_ *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
-	return mediaCommand(m.Author.ID, m.GuildID, media.RESUME, "")
+func playSound(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	return mediaCommand(s, m, media.PLAY, data)
 }
 
-func skipSound(_ *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
-	return mediaCommand(m.Author.ID, m.GuildID, media.SKIP, "")
+const (
+	searchResultCount      = 5
+	searchSelectionTimeout = 30 * time.Second
+)
+
+func searchSound(s *dgo.Session, m *dgo.MessageCreate, query string) (string, error) {
+	if query == "" {
+		return "Correct Syntax is: !search <search terms>", nil
+	}
+
+	_, err := getUserVoiceChannel(m.Author.ID, m.GuildID)
+	if err != nil {
+		return "You must be in a voice channel to play the song", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	results, err := media.Search(ctx, query, searchResultCount)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "No results found.", nil
+	}
+
+	var sb strings.Builder
+	for i, v := range results {
+		_, _ = fmt.Fprintf(&sb, "%d. %s | %v\n", i+1, v.Title, v.Duration)
+	}
+	_, _ = fmt.Fprintf(&sb, "Reply with a number within %v to choose a song.",
+		searchSelectionTimeout)
+
+	_, err = s.ChannelMessageSend(m.ChannelID, "**"+sb.String()+"**")
+	if err != nil {
+		return "", err
+	}
+
+	n, ok := awaitSelection(m.ChannelID, m.Author.ID, searchSelectionTimeout)
+	if !ok {
+		return "Search timed out.", nil
+	}
+	if n < 1 || n > len(results) {
+		return "Invalid selection.", nil
+	}
+
+	return mediaCommand(s, m, media.PLAY, results[n-1].URL)
 }
 
-func disconnectVoice(_ *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
-	return mediaCommand(m.Author.ID, m.GuildID, media.DISCONNECT, "")
+func pauseSound(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.PAUSE, "")
 }
 
-func inspectQueue(_ *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
-	return mediaCommand(m.Author.ID, m.GuildID, media.INSPECT, "")
+func resumeSound(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.RESUME, "")
+}
+
+func skipSound(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.SKIP, "")
+}
+
+// defaultSkipThreshold is the fraction of listeners who must vote to skip a song if vote skipping
+// is turned on without giving a threshold.
+const defaultSkipThreshold = 0.5
+
+func voteSkipSettings(_ *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	args := strings.Fields(data)
+	if len(args) == 0 {
+		settings, err := bot.store.GetSkipSettings(m.GuildID)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		if !settings.Vote {
+			return "Vote skipping is off", nil
+		}
+		return fmt.Sprintf("Vote skipping is on, %.0f%% of listeners must vote to skip",
+			settings.Threshold*100), nil
+	}
+
+	settings := store.SkipSettings{Threshold: defaultSkipThreshold}
+	switch {
+	case args[0] == "off" && len(args) == 1:
+	case args[0] == "on" && len(args) == 1:
+		settings.Vote = true
+	case args[0] == "on" && len(args) == 2:
+		percent, err := strconv.Atoi(strings.TrimSuffix(args[1], "%"))
+		if err != nil || percent < 1 || percent > 100 {
+			return "Threshold must be a percentage between 1 and 100", nil
+		}
+		settings.Vote = true
+		settings.Threshold = float64(percent) / 100
+	default:
+		return "Correct Syntax is: !voteskip on [percentage of listeners] or !voteskip off", nil
+	}
+
+	err := bot.store.SetSkipSettings(m.GuildID, settings)
+	if err != nil {
+		return "", err
+	}
+
+	return "Vote skip settings successfully updated", nil
+}
+
+func setVolume(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	return audioCommand(s, m, media.VOLUME, data)
+}
+
+func setNormalize(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	return audioCommand(s, m, media.NORMALIZE, data)
+}
+
+// audioCommand sends a command changing the audio settings of the guild, which can be done from
+// outside of a voice channel and while nothing is playing.
+func audioCommand(s *dgo.Session, m *dgo.MessageCreate, k media.Action,
+	data string) (string, error) {
+
+	user := media.User{
+		ID:         m.Author.ID,
+		Privileged: userPermissionLevel(s, m) >= botdj,
+	}
+	return sendMedia(m.GuildID, "", user, k, data)
+}
+
+func setFilter(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	return mediaCommand(s, m, media.FILTER, data)
+}
+
+// minIdleTimeout and maxIdleTimeout are the shortest and longest times the bot can be set to wait
+// before leaving a voice channel. Shorter timeouts could expire between one song and the next.
+const (
+	minIdleTimeout = 5 * time.Second
+	maxIdleTimeout = time.Hour
+)
+
+func idleTimeouts(_ *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	settings, err := bot.store.GetIdleSettings(m.GuildID)
+	if err == sql.ErrNoRows {
+		settings = media.DefaultIdleSettings
+	} else if err != nil {
+		return "", err
+	}
+
+	args := strings.Fields(data)
+	if len(args) == 0 {
+		return fmt.Sprintf("Leaving %v after the queue ends and %v after everyone leaves",
+			settings.QueueTimeout, settings.AloneTimeout), nil
+	}
+
+	if len(args) != 2 || (args[0] != "idle" && args[0] != "alone") {
+		return "Correct Syntax is: !timeout idle|alone <duration>", nil
+	}
+	d, err := time.ParseDuration(args[1])
+	if err != nil || d < minIdleTimeout || d > maxIdleTimeout {
+		return fmt.Sprintf("Timeout must be a duration such as 30s or 5m, from %v up to %v",
+			minIdleTimeout, maxIdleTimeout), nil
+	}
+
+	if args[0] == "idle" {
+		settings.QueueTimeout = d
+	} else {
+		settings.AloneTimeout = d
+	}
+	err = bot.store.SetIdleSettings(m.GuildID, settings)
+	if err != nil {
+		return "", err
+	}
+
+	return "Timeout successfully updated", nil
+}
+
+func seekSound(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if data == "" {
+		return "Correct Syntax is: !seek <time>", nil
+	}
+	return mediaCommand(s, m, media.SEEK, data)
+}
+
+func forwardSound(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if data == "" {
+		return "Correct Syntax is: !forward <time>", nil
+	}
+	return mediaCommand(s, m, media.FORWARD, data)
+}
+
+func rewindSound(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if data == "" {
+		return "Correct Syntax is: !rewind <time>", nil
+	}
+	return mediaCommand(s, m, media.REWIND, data)
+}
+
+func summonPlayer(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.SUMMON, "")
+}
+
+func disconnectVoice(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.DISCONNECT, "")
+}
+
+func inspectQueue(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.INSPECT, "")
+}
+
+func nowPlaying(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	response, err := mediaRequest(s, m, media.NOWPLAYING, "")
+	if err != nil {
+		return "", err
+	}
+	if response.Status != media.StatusOK || response.Track == nil {
+		return responseMessage(response), nil
+	}
+	return response.Message + "\nRequested by: " +
+		displayName(s, m.GuildID, response.Track.Requester), nil
+}
+
+func removeSong(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if len(strings.Fields(data)) != 1 {
+		return "Correct Syntax is: !remove <queue position>", nil
+	}
+	return mediaCommand(s, m, media.REMOVE, data)
+}
+
+func moveSong(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if len(strings.Fields(data)) != 2 {
+		return "Correct Syntax is: !move <from position> <to position>", nil
+	}
+	return mediaCommand(s, m, media.MOVE, data)
+}
+
+func shuffleQueue(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.SHUFFLE, "")
+}
+
+func clearQueue(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.CLEAR, "")
+}
+
+func loopQueue(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if data == "" {
+		return "Correct Syntax is: !loop off|track|queue", nil
+	}
+	return mediaCommand(s, m, media.LOOP, data)
+}
+
+func restoreQueue(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	return mediaCommand(s, m, media.RESTORE, "")
+}
+
+const playlistSyntax = "Correct Syntax is: !playlist save|load|delete [guild] <name>, " +
+	"!playlist add [guild] <name> <song>, or !playlist list. Playlist names cannot contain spaces."
+
+// playlistCommand manages saved playlists. Playlists belong to the user who made them unless the
+// "guild" keyword is given, in which case they belong to the guild and can only be modified by DJs.
+func playlistCommand(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	args := strings.Fields(data)
+	if len(args) == 0 {
+		return playlistSyntax, nil
+	}
+
+	sub := args[0]
+	if sub == "list" {
+		return listPlaylists(m)
+	}
+
+	ownerID := m.Author.ID
+	args = args[1:]
+	if len(args) > 0 && args[0] == "guild" {
+		ownerID = m.GuildID
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return playlistSyntax, nil
+	}
+	name := args[0]
+	// Only add takes anything after the name, so more words mean a name with spaces in it.
+	if sub != "add" && len(args) > 1 {
+		return playlistSyntax, nil
+	}
+
+	if ownerID == m.GuildID && sub != "load" &&
+		userPermissionLevel(s, m) < botdj {
+		return "Only DJs can modify guild playlists.", nil
+	}
+
+	switch sub {
+	case "save":
+		user := media.User{ID: m.Author.ID, Privileged: true}
+		return sendMedia(m.GuildID, "", user, media.SAVEPLAYLIST, ownerID+" "+name)
+
+	case "load":
+		return mediaCommand(s, m, media.LOADPLAYLIST, ownerID+" "+name)
+
+	case "add":
+		if len(args) < 2 {
+			return playlistSyntax, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		refs, err := media.Lookup(ctx, strings.Join(args[1:], " "))
+		cancel()
+		if err != nil {
+			return "Song not found.", nil
+		}
+		if limit := media.PlaylistLimit(); len(refs) > limit {
+			refs = refs[:limit]
+		}
+		err = bot.store.AddToPlaylist(ownerID, name, refs)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Added %d songs to playlist %s.", len(refs), name), nil
+
+	case "delete":
+		_, err := bot.store.GetPlaylist(ownerID, name)
+		if err == sql.ErrNoRows {
+			return fmt.Sprintf("Playlist \"%v\" doesn't exist", name), nil
+		} else if err != nil {
+			return "", err
+		}
+		err = bot.store.DeletePlaylist(ownerID, name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Playlist \"%v\" successfully removed!", name), nil
+	}
+
+	return playlistSyntax, nil
+}
+
+func listPlaylists(m *dgo.MessageCreate) (string, error) {
+	userPlaylists, err := bot.store.GetPlaylists(m.Author.ID)
+	if err != nil {
+		return "", err
+	}
+	guildPlaylists, err := bot.store.GetPlaylists(m.GuildID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(userPlaylists) == 0 && len(guildPlaylists) == 0 {
+		return "No saved playlists", nil
+	}
+
+	var sb strings.Builder
+	if len(userPlaylists) > 0 {
+		_, _ = fmt.Fprintf(&sb, "Your playlists: %v\n", strings.Join(userPlaylists, ", "))
+	}
+	if len(guildPlaylists) > 0 {
+		_, _ = fmt.Fprintf(&sb, "Guild playlists: %v\n", strings.Join(guildPlaylists, ", "))
+	}
+	return sb.String(), nil
 }
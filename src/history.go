@@ -0,0 +1,90 @@
+package strife
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/media"
+)
+
+const (
+	historyLength = 10
+	statsLength   = 5
+)
+
+func showHistory(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	plays, err := bot.store.GetHistory(m.GuildID, historyLength)
+	if err != nil {
+		return "", err
+	}
+	if len(plays) == 0 {
+		return "Nothing has been played yet.", nil
+	}
+
+	var sb strings.Builder
+	for i, v := range plays {
+		_, _ = fmt.Fprintf(&sb, "%d. %s | Requested by %s | %s ago", i+1, v.Track.Title,
+			displayName(s, m.GuildID, v.Track.Requester),
+			time.Since(v.Started).Truncate(time.Minute))
+		if v.Skipped {
+			sb.WriteString(" (skipped)")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+func replaySound(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if data == "" {
+		return "Correct Syntax is: !replay <position in history>", nil
+	}
+	return mediaCommand(s, m, media.REPLAY, data)
+}
+
+func showStats(s *dgo.Session, m *dgo.MessageCreate, _ string) (string, error) {
+	tracks, err := bot.store.GetTopTracks(m.GuildID, statsLength)
+	if err != nil {
+		return "", err
+	}
+	requesters, err := bot.store.GetTopRequesters(m.GuildID, statsLength)
+	if err != nil {
+		return "", err
+	}
+	if len(tracks) == 0 {
+		return "Nothing has been played yet.", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Top songs:\n")
+	for i, v := range tracks {
+		_, _ = fmt.Fprintf(&sb, "%d. %s | %d plays\n", i+1, v.Track.Title, v.Plays)
+	}
+	if len(requesters) > 0 {
+		sb.WriteString("Top requesters:\n")
+		for i, v := range requesters {
+			_, _ = fmt.Fprintf(&sb, "%d. %s | %d plays\n", i+1,
+				displayName(s, m.GuildID, v.Requester), v.Plays)
+		}
+	}
+	return sb.String(), nil
+}
+
+// displayName returns the name of a member of the guild, without mentioning them.
+func displayName(s *dgo.Session, guildID, userID string) string {
+	if userID == "" {
+		return "unknown"
+	}
+	member, err := s.State.Member(guildID, userID)
+	if err != nil {
+		member, err = s.GuildMember(guildID, userID)
+		if err != nil {
+			return "unknown"
+		}
+	}
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.Username
+}
@@ -14,11 +14,31 @@ func _() {
 	_ = x[SKIP-3]
 	_ = x[DISCONNECT-4]
 	_ = x[INSPECT-5]
+	_ = x[SEEK-6]
+	_ = x[FORWARD-7]
+	_ = x[REWIND-8]
+	_ = x[REMOVE-9]
+	_ = x[MOVE-10]
+	_ = x[SHUFFLE-11]
+	_ = x[CLEAR-12]
+	_ = x[LOOP-13]
+	_ = x[RESTORE-14]
+	_ = x[SAVEPLAYLIST-15]
+	_ = x[LOADPLAYLIST-16]
+	_ = x[REPLAY-17]
+	_ = x[NOWPLAYING-18]
+	_ = x[VOLUME-19]
+	_ = x[NORMALIZE-20]
+	_ = x[FILTER-21]
+	_ = x[SUMMON-22]
+	_ = x[SOUNDBOARD-23]
+	_ = x[SAY-24]
+	_ = x[RECORD-25]
 }
 
-const _Action_name = "PLAYPAUSERESUMESKIPDISCONNECTINSPECT"
+const _Action_name = "PLAYPAUSERESUMESKIPDISCONNECTINSPECTSEEKFORWARDREWINDREMOVEMOVESHUFFLECLEARLOOPRESTORESAVEPLAYLISTLOADPLAYLISTREPLAYNOWPLAYINGVOLUMENORMALIZEFILTERSUMMONSOUNDBOARDSAYRECORD"
 
-var _Action_index = [...]uint8{0, 4, 9, 15, 19, 29, 36}
+var _Action_index = [...]uint8{0, 4, 9, 15, 19, 29, 36, 40, 47, 53, 59, 63, 70, 75, 79, 86, 98, 110, 116, 126, 132, 141, 147, 153, 163, 166, 172}
 
 func (i Action) String() string {
 	if i < 0 || i >= Action(len(_Action_index)-1) {
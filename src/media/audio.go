@@ -0,0 +1,97 @@
+package media
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultVolume = 100
+	maxVolume     = 200
+	// loudnormFilter normalizes loudness to the EBU R128 standard.
+	loudnormFilter = "loudnorm=I=-16:TP=-1.5:LRA=11"
+)
+
+// loadAudioSettings returns the audio settings of the guild, or the defaults if none have been set.
+func loadAudioSettings(st store.Store, guildID string) store.AudioSettings {
+	settings, err := st.GetAudioSettings(guildID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Msg("")
+		}
+		return store.AudioSettings{Volume: defaultVolume}
+	}
+	return settings
+}
+
+// updateAudioSettings applies a volume or normalize command to the audio settings of the guild.
+// It returns the new settings, whether they were changed, and the outcome for the user. Commands
+// without data report the current setting.
+func updateAudioSettings(st store.Store, guildID string, commandType Action,
+	data string) (store.AudioSettings, bool, Response) {
+
+	settings := loadAudioSettings(st, guildID)
+	data = strings.TrimSpace(data)
+
+	switch commandType {
+	case volume:
+		if data == "" {
+			return settings, false, okReply(fmt.Sprintf("Volume is %d%%.", settings.Volume))
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(data, "%"))
+		if err != nil || n < 0 || n > maxVolume {
+			return settings, false, errReply(StatusInvalid, ErrInvalidRequest,
+				fmt.Sprintf("Volume must be between 0 and %d.", maxVolume))
+		}
+		settings.Volume = n
+
+	case normalize:
+		switch strings.ToLower(data) {
+		case "":
+			if settings.Normalize {
+				return settings, false, okReply("Normalization is on.")
+			}
+			return settings, false, okReply("Normalization is off.")
+		case "on":
+			settings.Normalize = true
+		case "off":
+			settings.Normalize = false
+		default:
+			return settings, false, errReply(StatusInvalid, ErrInvalidRequest,
+				"Normalization must be on or off.")
+		}
+	}
+
+	err := st.SetAudioSettings(guildID, settings)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return settings, false, errReply(StatusFailed, err, "Couldn't save audio settings.")
+	}
+
+	if commandType == volume {
+		return settings, true, okReply(fmt.Sprintf("Volume set to %d%%.", settings.Volume))
+	}
+	if settings.Normalize {
+		return settings, true, okReply("Normalization turned on.")
+	}
+	return settings, true, okReply("Normalization turned off.")
+}
+
+// audioFilter returns the ffmpeg audio filter chain which applies the audio effects followed by
+// the audio settings.
+func audioFilter(settings store.AudioSettings, effects audioEffects) string {
+	filters := effects.filters()
+	if settings.Normalize {
+		filters = append(filters, loudnormFilter)
+	}
+	if settings.Volume != defaultVolume {
+		filters = append(filters, fmt.Sprintf("volume=%.2f", float64(settings.Volume)/100))
+	}
+	return strings.Join(filters, ",")
+}
@@ -0,0 +1,385 @@
+package media
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	cacheFileExt = ".audio"
+	cacheTempExt = ".part"
+	// cacheMetaExt is the extension of the file beside each cached song which describes it, so
+	// that a request for the song can be played from the cache without resolving it.
+	cacheMetaExt = ".json"
+)
+
+// cache holds downloaded audio on disk, so that songs which are played again do not need to be
+// downloaded again. It is nil if caching is disabled.
+var (
+	cache   *audioCache
+	cacheMu sync.RWMutex
+)
+
+// audioCache is an on disk cache of audio, limited in size by evicting the least recently used
+// files.
+type audioCache struct {
+	dir     string
+	maxSize int64
+
+	size    int64
+	entries map[string]*list.Element
+	// lru holds the cacheEntry of every file, with the most recently used at the front.
+	lru *list.List
+	sync.Mutex
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+	// track describes the cached song, and is nil if it is not known.
+	track *store.TrackRef
+}
+
+// EnableCache caches downloaded audio in dir, keeping the total size of the cache below maxSize
+// bytes. Files already in dir are kept, so that the cache persists between runs.
+func EnableCache(dir string, maxSize int64) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	c := &audioCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	err = c.load()
+	if err != nil {
+		return err
+	}
+
+	cacheMu.Lock()
+	cache = c
+	cacheMu.Unlock()
+	return nil
+}
+
+func currentCache() *audioCache {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cache
+}
+
+// load indexes the files in the cache directory, ordering them by the time they were last used.
+func (c *audioCache) load() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type file struct {
+		cacheEntry
+		used time.Time
+	}
+	var files []file
+	for _, v := range dirEntries {
+		path := filepath.Join(c.dir, v.Name())
+		// Partial downloads are left behind if the bot stopped while caching a song.
+		if strings.HasSuffix(v.Name(), cacheTempExt) {
+			_ = os.Remove(path)
+			continue
+		}
+		// Descriptions are left behind if the bot stopped while evicting a song.
+		if strings.HasSuffix(v.Name(), cacheMetaExt) {
+			audio := strings.TrimSuffix(path, cacheMetaExt) + cacheFileExt
+			if _, err := os.Stat(audio); os.IsNotExist(err) {
+				_ = os.Remove(path)
+			}
+			continue
+		}
+		if v.IsDir() || !strings.HasSuffix(v.Name(), cacheFileExt) {
+			continue
+		}
+		info, err := v.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{
+			cacheEntry: cacheEntry{name: v.Name(), size: info.Size(), track: c.readMeta(v.Name())},
+			used:       info.ModTime(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].used.After(files[j].used)
+	})
+
+	c.Lock()
+	defer c.Unlock()
+	for _, v := range files {
+		entry := v.cacheEntry
+		c.entries[v.name] = c.lru.PushBack(&entry)
+		c.size += v.size
+	}
+	c.evict()
+
+	return nil
+}
+
+// cacheFileName returns the name of the file holding the audio of track.
+func cacheFileName(t Track) string {
+	return cacheName(t.Source(), t.ID())
+}
+
+// cacheName returns the name of the file holding the audio of the track with the given source and
+// ID.
+func cacheName(source, id string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + id))
+	return hex.EncodeToString(sum[:]) + cacheFileExt
+}
+
+// metaPath returns the path of the description of the cached file with the given name.
+func (c *audioCache) metaPath(name string) string {
+	return filepath.Join(c.dir, strings.TrimSuffix(name, cacheFileExt)+cacheMetaExt)
+}
+
+// readMeta returns the description of the cached file with the given name, or nil if there is
+// none.
+func (c *audioCache) readMeta(name string) *store.TrackRef {
+	b, err := os.ReadFile(c.metaPath(name))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Msg("Couldn't read cached song description")
+		}
+		return nil
+	}
+	var ref store.TrackRef
+	if err := json.Unmarshal(b, &ref); err != nil {
+		log.Error().Err(err).Msg("Couldn't read cached song description")
+		return nil
+	}
+	return &ref
+}
+
+// cachedTrack returns the track with the given source and ID if it is in the cache along with its
+// description, so that it can be played without being resolved.
+func cachedTrack(source, id string) (Track, bool) {
+	c := currentCache()
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[cacheName(source, id)]
+	if !ok || e.Value.(*cacheEntry).track == nil {
+		return nil, false
+	}
+	return &storedTrack{ref: *e.Value.(*cacheEntry).track}, true
+}
+
+// open returns the cached audio of t, if there is any.
+func (c *audioCache) open(t Track) (io.ReadCloser, bool) {
+	name := cacheFileName(t)
+
+	c.Lock()
+	e, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't open cached audio")
+		c.remove(name)
+		return nil, false
+	}
+	// The modification time records when the file was last used, so that the order of use is
+	// kept between runs.
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return f, true
+}
+
+// cached reports whether the audio of t is in the cache.
+func cached(t Track) bool {
+	c := currentCache()
+	if c == nil {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.entries[cacheFileName(t)]
+	return ok
+}
+
+// add moves the temporary file at tempPath into the cache as the audio of the track t, evicting
+// older files if the cache is too large.
+func (c *audioCache) add(t Track, tempPath string) {
+	name := cacheFileName(t)
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return
+	}
+	if info.Size() > c.maxSize {
+		_ = os.Remove(tempPath)
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	err = os.Rename(tempPath, filepath.Join(c.dir, name))
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't add audio to cache")
+		_ = os.Remove(tempPath)
+		return
+	}
+
+	ref := store.TrackRef{Source: t.Source(), ID: t.ID(), Title: t.Title(), Duration: t.Duration()}
+	b, err := json.Marshal(ref)
+	if err == nil {
+		err = os.WriteFile(c.metaPath(name), b, 0644)
+	}
+	var track *store.TrackRef
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't describe cached song")
+	} else {
+		track = &ref
+	}
+
+	if e, ok := c.entries[name]; ok {
+		c.size -= e.Value.(*cacheEntry).size
+		c.lru.Remove(e)
+	}
+	c.entries[name] = c.lru.PushFront(&cacheEntry{name: name, size: info.Size(), track: track})
+	c.size += info.Size()
+	c.evict()
+}
+
+// evict removes the least recently used files until the cache fits within its maximum size. The
+// cache must be locked.
+func (c *audioCache) evict() {
+	for c.size > c.maxSize {
+		e := c.lru.Back()
+		if e == nil {
+			return
+		}
+		entry := e.Value.(*cacheEntry)
+		err := os.Remove(filepath.Join(c.dir, entry.name))
+		if err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Msg("Couldn't evict cached audio")
+		}
+		_ = os.Remove(c.metaPath(entry.name))
+		c.lru.Remove(e)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+	}
+}
+
+func (c *audioCache) remove(name string) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[name]; ok {
+		_ = os.Remove(filepath.Join(c.dir, name))
+		_ = os.Remove(c.metaPath(name))
+		c.size -= e.Value.(*cacheEntry).size
+		c.lru.Remove(e)
+		delete(c.entries, name)
+	}
+}
+
+// openTrack opens t, reading it from the cache if possible. Otherwise, tracks of known length are
+// added to the cache once they have been downloaded in full. Cached tracks do not need to be
+// resolved by their source, so they can be played even while it is unreachable.
+func openTrack(ctx context.Context, t Track) (io.ReadCloser, error) {
+	c := currentCache()
+	if c == nil {
+		return t.Open(ctx)
+	}
+
+	if r, ok := c.open(t); ok {
+		log.Info().Str("Title", t.Title()).Msg("Playing from cache")
+		return r, nil
+	}
+
+	stream, err := t.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Live streams never finish, so cannot be cached, and local files do not need to be. Speech
+	// has no known length, so is never cached.
+	if t.Duration() == 0 || t.Source() == librarySourceName || t.Source() == soundSourceName {
+		return stream, nil
+	}
+
+	f, err := os.CreateTemp(c.dir, "*"+cacheTempExt)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return stream, nil
+	}
+
+	return &cachingReader{ReadCloser: stream, file: f, track: t, cache: c}, nil
+}
+
+// cachingReader copies everything read from a track into a temporary file, which is added to the
+// cache if the track is read to the end.
+type cachingReader struct {
+	io.ReadCloser
+	file  *os.File
+	track Track
+	cache *audioCache
+
+	complete bool
+	failed   bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && !r.failed {
+		if _, werr := r.file.Write(p[:n]); werr != nil {
+			log.Error().Err(werr).Msg("Couldn't write to cache")
+			r.failed = true
+		}
+	}
+	if err == io.EOF {
+		r.complete = true
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	err := r.ReadCloser.Close()
+
+	tempPath := r.file.Name()
+	if cerr := r.file.Close(); cerr != nil {
+		r.failed = true
+	}
+	if r.complete && !r.failed {
+		r.cache.add(r.track, tempPath)
+	} else {
+		_ = os.Remove(tempPath)
+	}
+
+	return err
+}
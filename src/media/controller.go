@@ -2,18 +2,22 @@ package media
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	lg "log"
+	"math/rand"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	dgo "github.com/bwmarrin/discordgo"
 	"github.com/dpatterbee/bpipe"
+	"github.com/dpatterbee/strife/src/store"
 	"github.com/jonas747/dca"
-	yt "github.com/kkdai/youtube/v2"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,10 +28,55 @@ const (
 	skip
 	disconnect
 	inspect
+	seek
+	forward
+	rewind
+	remove
+	move
+	shuffle
+	clear
+	loop
+	restore
+	savePlaylist
+	loadPlaylist
+	replay
+	nowPlaying
+	volume
+	normalize
+	filter
+	summon
+	soundboard
+	say
+	record
+)
+
+// listenersChanged is sent to a player when the members of a voice channel in its guild change,
+// and playerShutdown is sent to disconnect a player when the controller shuts down. They cannot be
+// requested by users.
+const (
+	listenersChanged = -1
+	playerShutdown   = -2
 )
 
 const stdTimeout = time.Millisecond * 500
 
+// maxQueueLength is the maximum number of songs which may be waiting in a guild's queue.
+const maxQueueLength = 100
+
+// playlistLimit is the maximum number of songs which a single request may add to the queue.
+var playlistLimit int32 = 50
+
+// SetPlaylistLimit sets the maximum number of songs which a single request, such as a playlist,
+// may add to the queue.
+func SetPlaylistLimit(n int) {
+	atomic.StoreInt32(&playlistLimit, int32(n))
+}
+
+// PlaylistLimit returns the maximum number of songs which a single request may add to the queue.
+func PlaylistLimit() int {
+	return int(atomic.LoadInt32(&playlistLimit))
+}
+
 type mediaSession struct {
 	download *downloadSession
 	encode   *dca.EncodeSession
@@ -35,24 +84,41 @@ type mediaSession struct {
 }
 
 type playerCommand struct {
-	commandType   Action
-	returnChannel chan string
+	commandType Action
+	commandData string
+	// channelID is the voice channel of the user making the command.
+	channelID     string
+	user          User
+	returnChannel chan Response
 }
 
 type downloadSession struct {
 	cancel context.CancelFunc
+	// opened receives the outcome of opening the track, before any of it is streamed.
+	opened chan error
 	sync.Mutex
 }
 
 type songReq struct {
-	URL        string
-	returnChan chan string
+	URL       string
+	requester string
+	// channelID is the voice channel the request was made from.
+	channelID string
+	// restore is true if the request is for the guild's saved queue rather than a URL.
+	restore bool
+	// playlistOwner and playlistName are set if the request is for a saved playlist.
+	playlistOwner string
+	playlistName  string
+	// replay is set to the position in the guild's play history of the song to be replayed.
+	replay     int
+	returnChan chan Response
 }
 
 // mediaControlRouter function runs perpetually, maintaining a pool of active media sessions.
 // It routes commands to the correct channel, creating a new media session if one is required to
 // fulfill the request.
-func controller(session *dgo.Session, mediaCommandChannel chan Request) {
+func controller(session *dgo.Session, st store.Store, events *eventBus,
+	mediaCommandChannel chan Request, voiceChannel chan string, shutdownChannel chan chan bool) {
 
 	type activeMC struct {
 		songChannel    chan songReq
@@ -79,6 +145,27 @@ func controller(session *dgo.Session, mediaCommandChannel chan Request) {
 	mediaReturnBegin := make(chan string)
 	mediaReturnEnd := make(chan string)
 
+	// recorders holds the control channel of each guild's recording. A recording which has stopped
+	// but is still being saved has a nil channel. Recording and playing media in a guild are
+	// mutually exclusive, as each needs its own voice connection.
+	// recordStop and recordEnd are used by guildRecorder goroutines in the same way as
+	// mediaReturnBegin and mediaReturnEnd.
+	recorders := make(map[string]chan playerCommand)
+	recordStop := make(chan string)
+	recordEnd := make(chan string)
+
+	// shutdownDone is set once the controller has been told to shut down, and is closed once
+	// every player and recording has finished.
+	var shutdownDone chan bool
+	checkShutdown := func() {
+		if shutdownDone != nil && len(activeMCs) == 0 && len(dyingMCs) == 0 &&
+			len(recorders) == 0 {
+			close(shutdownDone)
+			shutdownDone = nil
+		}
+	}
+	shuttingDown := false
+
 	// This loops for the lifetime of the program, responding to messages sent on each channel.
 	for {
 
@@ -87,13 +174,48 @@ func controller(session *dgo.Session, mediaCommandChannel chan Request) {
 			// play and disconnect are special cases of command, as they create and destroy channels
 			// all other commands just get passed through to the respective server.
 			switch req.CommandType {
-			case play:
+			case play, restore, loadPlaylist, replay, soundboard, say:
+
+				if shuttingDown {
+					go trySend(req.ReturnChan, errReply(StatusBusy, ErrShuttingDown, "Shutting down."),
+						stdTimeout)
+					break
+				}
+				if _, ok := recorders[req.GuildID]; ok {
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrRecording,
+						"Cannot play media while recording."), stdTimeout)
+					break
+				}
+
+				var replayPos int
+				if req.CommandType == replay {
+					n, err := strconv.Atoi(strings.TrimSpace(req.CommandData))
+					if err != nil || n < 1 {
+						go trySend(req.ReturnChan, errReply(StatusInvalid, ErrInvalidRequest,
+							"Invalid history position."), stdTimeout)
+						break
+					}
+					replayPos = n
+				}
 
 				ch, ok := activeMCs[req.GuildID]
+				if ok && !inPlayerChannel(session, req) {
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrNotInChannel, notInChannel),
+						stdTimeout)
+					break
+				}
+				// Clips are played straight away rather than being queued. An active player
+				// interrupts the current song, and otherwise a player is started to play the clip
+				// alone.
+				isClip := req.CommandType == soundboard || req.CommandType == say
+				if ok && isClip {
+					go reqPass(ch.controlChannel, req)
+					break
+				}
 				if !ok {
 					activeMCs[req.GuildID] = activeMC{
 						controlChannel: make(chan playerCommand, 5),
-						songChannel:    make(chan songReq, 100),
+						songChannel:    make(chan songReq, maxQueueLength),
 					}
 
 					ch = activeMCs[req.GuildID]
@@ -106,8 +228,15 @@ func controller(session *dgo.Session, mediaCommandChannel chan Request) {
 						waitChan = make(chan bool)
 						dyingMCs[req.GuildID] = dyingMC{blocking: true, waitChan: waitChan}
 					}
+					var clip *playerCommand
+					if isClip {
+						c := newPlayerCommand(req)
+						clip = &c
+					}
 					go guildSoundPlayer(
 						session,
+						st,
+						events,
 						req.GuildID,
 						req.ChannelID,
 						ch.controlChannel,
@@ -115,47 +244,132 @@ func controller(session *dgo.Session, mediaCommandChannel chan Request) {
 						mediaReturnBegin,
 						mediaReturnEnd,
 						waitChan,
+						clip,
 					)
+					if isClip {
+						break
+					}
 				}
 
 				songReq := songReq{
 					URL:        req.CommandData,
+					requester:  req.User.ID,
+					channelID:  req.ChannelID,
+					restore:    req.CommandType == restore,
+					replay:     replayPos,
 					returnChan: req.ReturnChan,
 				}
+				if req.CommandType == loadPlaylist {
+					songReq.playlistOwner, songReq.playlistName = splitPlaylistData(req.CommandData)
+				}
 
 				select {
 				case ch.songChannel <- songReq:
 				default:
-					go trySend(req.ReturnChan, "Queue full, please try again later.", stdTimeout)
+					go trySend(req.ReturnChan, errReply(StatusBusy, ErrQueueFull,
+						"Queue full, please try again later."), stdTimeout)
 				}
 
 			case disconnect:
 				mediaChannel, ok := activeMCs[req.GuildID]
+				if ok && !inPlayerChannel(session, req) {
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrNotInChannel, notInChannel),
+						stdTimeout)
+					break
+				}
 
 				if ok {
 					// TODO: There's definitely a potential scenario where this never properly
 					// sends the disconnect signal and we end up with a zombie goroutine holding
 					// onto a voiceconnection for a while.
-					go reqPassTimeoutClose(mediaChannel.controlChannel, req, 10*time.Minute)
+					go reqPassTimeout(mediaChannel.controlChannel, req, 10*time.Minute)
 
 					dyingMCs[req.GuildID] = dyingMC{blocking: false, waitChan: nil}
 					delete(activeMCs, req.GuildID)
+				} else {
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrNotPlaying,
+						"No media playing."), stdTimeout)
+				}
+
+			case record:
+				verb, _ := splitRecordData(req.CommandData)
+				rec, recording := recorders[req.GuildID]
+				_, playing := activeMCs[req.GuildID]
+				_, dying := dyingMCs[req.GuildID]
+				switch {
+				case verb == "stop" && rec != nil:
+					go reqPass(rec, req)
+				case verb == "stop":
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrNotFound, "Not recording."),
+						stdTimeout)
+				case shuttingDown:
+					go trySend(req.ReturnChan, errReply(StatusBusy, ErrShuttingDown, "Shutting down."),
+						stdTimeout)
+				case rec != nil:
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrRecording,
+						"Already recording."), stdTimeout)
+				case recording:
+					go trySend(req.ReturnChan, errReply(StatusBusy, ErrRecording,
+						"The last recording is still being saved."), stdTimeout)
+				case playing || dying:
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrRecording,
+						"Cannot record while media is playing."), stdTimeout)
+				default:
+					rec = make(chan playerCommand, 5)
+					recorders[req.GuildID] = rec
+					go guildRecorder(session, req.GuildID, req.ChannelID, newPlayerCommand(req), rec,
+						recordStop, recordEnd)
 				}
 
 			default:
 
 				mc, ok := activeMCs[req.GuildID]
-				if ok {
+				// Whether a summon is allowed depends on who is listening to the player, so
+				// the player decides.
+				if ok && req.CommandType != summon && !inPlayerChannel(session, req) {
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrNotInChannel, notInChannel),
+						stdTimeout)
+				} else if ok {
 					go reqPass(mc.controlChannel, req)
+				} else if req.CommandType == volume || req.CommandType == normalize {
+					// Audio settings can be changed while nothing is playing, taking effect once
+					// a player starts.
+					go func(req Request) {
+						_, _, reply := updateAudioSettings(st, req.GuildID, req.CommandType,
+							req.CommandData)
+						trySend(req.ReturnChan, reply, stdTimeout)
+					}(req)
+				} else {
+					go trySend(req.ReturnChan, errReply(StatusRejected, ErrNotPlaying,
+						"No media playing."), stdTimeout)
 				}
 			}
+		case guildID := <-voiceChannel:
+			// Voice state updates are frequent, so they are passed on without blocking. One
+			// dropped because the player or recorder is busy is made up for by the next.
+			if mc, ok := activeMCs[guildID]; ok {
+				notifyListeners(mc.controlChannel)
+			}
+			if rec := recorders[guildID]; rec != nil {
+				notifyListeners(rec)
+			}
+
+		case guildID := <-recordStop:
+			if _, ok := recorders[guildID]; ok {
+				recorders[guildID] = nil
+			}
+
+		case guildID := <-recordEnd:
+			delete(recorders, guildID)
+			checkShutdown()
+
 		case guildID := <-mediaReturnBegin:
 
 			// When a guildSoundPlayer goroutine informs us that they are beginning to shut down,
-			// we close the communications channels and drain them,
-			// then create an entry in our dyingMCs map and remove from activeMCs
-			m, ok := activeMCs[guildID]
-			close(m.controlChannel)
+			// we create an entry in our dyingMCs map and remove it from activeMCs, so that no
+			// more requests are passed to it. The control channel is not closed, as requests
+			// passed before now may still be waiting to be sent on it, and time out instead.
+			_, ok := activeMCs[guildID]
 			if ok {
 				dyingMCs[guildID] = dyingMC{}
 				delete(activeMCs, guildID)
@@ -174,77 +388,122 @@ func controller(session *dgo.Session, mediaCommandChannel chan Request) {
 				}
 				delete(dyingMCs, guildID)
 			}
+			checkShutdown()
+
+		case done := <-shutdownChannel:
+
+			// Every active player is disconnected, which saves its queue, and we signal done once
+			// they have all finished.
+			shuttingDown = true
+			shutdownDone = done
+			for guildID, mc := range activeMCs {
+				req := Request{CommandType: playerShutdown, GuildID: guildID}
+				go reqPassTimeout(mc.controlChannel, req, 10*time.Second)
 
+				dyingMCs[guildID] = dyingMC{blocking: false, waitChan: nil}
+				delete(activeMCs, guildID)
+			}
+			// Recordings are stopped and saved or uploaded as usual.
+			for guildID, rec := range recorders {
+				if rec != nil {
+					req := Request{CommandType: record, GuildID: guildID, CommandData: "stop"}
+					go reqPass(rec, req)
+				}
+			}
+			checkShutdown()
 		}
 
 	}
 
 }
 
+// notInChannel is the reply to requests which are rejected by inPlayerChannel.
+const notInChannel = "You must be in the same voice channel as the bot."
+
+// inPlayerChannel reports whether the request may be made to the guild's player, which is the case
+// if it was made from the voice channel the player is connected to or does not need a channel, or
+// if the user is privileged.
+func inPlayerChannel(session *dgo.Session, req Request) bool {
+	if req.ChannelID == "" || req.User.Privileged {
+		return true
+	}
+
+	session.RLock()
+	vc, ok := session.VoiceConnections[req.GuildID]
+	session.RUnlock()
+	if !ok {
+		// The player has not joined its channel yet.
+		return true
+	}
+
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.ChannelID == "" || vc.ChannelID == req.ChannelID
+}
+
+// newPlayerCommand returns the playerCommand passed to a player or recorder for req.
+func newPlayerCommand(req Request) playerCommand {
+	return playerCommand{
+		commandType:   req.CommandType,
+		commandData:   req.CommandData,
+		channelID:     req.ChannelID,
+		user:          req.User,
+		returnChannel: req.ReturnChan,
+	}
+}
+
+// notifyListeners tells the player or recorder listening on ch that the members of a voice channel
+// in its guild have changed, unless ch is full. It does not block, so that the controller can send
+// it directly.
+func notifyListeners(ch chan playerCommand) {
+	select {
+	case ch <- playerCommand{commandType: listenersChanged}:
+	default:
+	}
+}
+
 // reqPass passes a mediaRequest down a playerCommand channel, timing out after stdTimeout
 func reqPass(ch chan playerCommand, req Request) {
 	reqPassTimeout(ch, req, stdTimeout)
 }
 
-func reqPassTimeoutClose(ch chan playerCommand, req Request, timeout time.Duration) {
-	reqPassTimeout(ch, req, timeout)
-	close(ch)
-}
-
 func reqPassTimeout(ch chan playerCommand, req Request, timeout time.Duration) {
 	timer := time.NewTimer(timeout)
 	select {
-	case ch <- playerCommand{commandType: req.CommandType, returnChannel: req.ReturnChan}:
+	case ch <- newPlayerCommand(req):
 		timer.Stop()
 		return
 	case <-timer.C:
-		go trySend(req.ReturnChan, "Server busy", stdTimeout)
+		go trySend(req.ReturnChan, errReply(StatusBusy, ErrServerBusy, "Server busy"), stdTimeout)
 		return
 	}
 }
 
-// streamSong uses youtube-dl to download the song and pipe the stream of data to writePipe
-func streamSong(writePipe io.WriteCloser, video *yt.Video, d *downloadSession) {
-
-	client := yt.Client{}
+// streamSong opens the track and pipes the stream of data to writePipe
+func streamSong(writePipe io.WriteCloser, track Track, d *downloadSession) {
 
 	ctx, cancel := context.WithCancel(context.Background())
-	for i, v := range video.Formats {
-		log.Info().Msg(fmt.Sprintf("%v: %v", i, v.AudioQuality))
-	}
-	format, err := videoFormatFinder(video)
+	d.cancel = cancel
+
+	stream, err := openTrack(ctx, track)
+	d.Unlock()
+	d.opened <- err
 	if err != nil {
 		log.Error().Err(err).Msg("")
-		cancel()
 		err := writePipe.Close()
 		if err != nil {
 			log.Error().Err(err).Msg("")
 		}
+		return
 	}
-	stream, length, err := client.GetStreamContext(ctx, video, &video.Formats[format])
-	log.Info().Msg(fmt.Sprint(length))
 	defer func(stream io.ReadCloser) {
 		err := stream.Close()
 		if err != nil {
 			log.Error().Err(err).Msg("")
 		}
 	}(stream)
-	if err != nil {
-		log.Error().Err(err).Msg("")
-		cancel()
-		err := writePipe.Close()
-		if err != nil {
-			log.Error().Err(err).Msg("")
-		}
-		return
-	}
-	d.cancel = cancel
-	d.Unlock()
 
 	_, err = io.Copy(writePipe, stream)
-	if err != nil {
-		log.Error().Err(err).Msg("")
-	}
 
 	if err != nil {
 		switch err {
@@ -262,36 +521,13 @@ func streamSong(writePipe io.WriteCloser, video *yt.Video, d *downloadSession) {
 	}
 }
 
-var audioQualities = map[string]int{
-	"AUDIO_QUALITY_LOW":    1,
-	"AUDIO_QUALITY_MEDIUM": 2,
-	"AUDIO_QUALITY_HIGH":   3,
-}
-
-func videoFormatFinder(vid *yt.Video) (int, error) {
-	highestSoFar := 0
-	highestQualityIndex := 0
-	for i, v := range vid.Formats {
-		if v.AudioChannels == 0 || v.AudioQuality == "" {
-			continue
-		}
-		if j, ok := audioQualities[v.AudioQuality]; ok && j > highestSoFar {
-			highestSoFar = j
-			highestQualityIndex = i
-		}
-	}
+// newMediaSession starts downloading and encoding s, beginning playback start into the song.
+func newMediaSession(s Track, vc *dgo.VoiceConnection, start time.Duration,
+	audio store.AudioSettings, effects audioEffects) (*mediaSession, error) {
 
-	if vid.Formats[highestQualityIndex].AudioChannels == 0 {
-		return 0, errors.New("no audio found")
-	}
-
-	return highestQualityIndex, nil
-}
-
-func newMediaSession(s *yt.Video, vc *dgo.VoiceConnection) (*mediaSession, error) {
 	bufPipe := bpipe.New()
 
-	var d downloadSession
+	d := downloadSession{opened: make(chan error, 1)}
 	d.Lock()
 	go streamSong(bufPipe, s, &d)
 
@@ -299,12 +535,19 @@ func newMediaSession(s *yt.Video, vc *dgo.VoiceConnection) (*mediaSession, error
 	t := log.With().Str("level", "warn").Logger()
 	dca.Logger = lg.New(t, "", 0)
 
-	encode, err := dca.EncodeMem(bufPipe, dca.StdEncodeOptions)
+	options := *dca.StdEncodeOptions
+	// The start time is applied to the filtered output, so it is given in playback time rather
+	// than song time.
+	options.StartTime = int(start.Seconds() / effects.tempo())
+	options.AudioFilter = audioFilter(audio, effects)
+
+	encode, err := dca.EncodeMem(bufPipe, &options)
 	if err != nil {
 		return nil, err
 	}
 
-	stream := newStreamingSession(encode, vc)
+	offset := time.Duration(float64(options.StartTime) * effects.tempo() * float64(time.Second))
+	stream := newStreamingSession(encode, vc, offset, effects.tempo())
 
 	return &mediaSession{
 		download: &d,
@@ -334,72 +577,254 @@ func (d *downloadSession) safeCancel() {
 	d.Unlock()
 }
 
-func prettySongList(yts []*yt.Video, currentSongPos time.Duration) string {
+// prettySongList formats the queue for display. currentSongRemaining is the time left until the
+// current song finishes, and known is false if that time cannot be determined, such as for a live
+// stream. Songs are played at the given tempo, which scales the time taken to play them.
+func prettySongList(q queueSnapshot, currentSongRemaining time.Duration, known bool,
+	tempo float64) string {
 	var sb strings.Builder
-	durationUntilNow := currentSongPos
+	durationUntilNow := currentSongRemaining
+
+	if q.loop != loopOff {
+		_, _ = fmt.Fprintf(&sb, "Loop: %v\n", q.loop)
+	}
+	// When looping a track, the queue is not reached until looping is turned off.
+	if q.loop == loopTrack {
+		known = false
+	}
+
+	tracks := q.tracks
+
+	for i, v := range tracks {
+		playingIn := "unknown"
+		if known {
+			playingIn = durationUntilNow.Truncate(time.Second).String()
+		}
+
+		title := v.Title()
+		if v.Duration() == 0 {
+			title += " (live)"
+		}
 
-	for i, v := range yts {
 		// Writes to strings.Builder cannot error
-		_, _ = fmt.Fprintf(&sb, "%d. %s | Playing in: %v\n", i+1, v.Title,
-			durationUntilNow.Truncate(time.Second).String())
-		durationUntilNow = durationUntilNow + v.Duration
+		_, _ = fmt.Fprintf(&sb, "%d. %s | Playing in: %v\n", i+1, title, playingIn)
+
+		// Once a song of unknown length is reached, the start times of the songs after it are
+		// also unknown.
+		known = known && v.Duration() != 0
+		durationUntilNow = durationUntilNow + time.Duration(float64(v.Duration())/tempo)
 	}
 	return sb.String()
 }
 
+// enqueueTracks appends as many of tracks to queue as the queue limits and the guild's policy
+// allow, adding no more than limit tracks. It returns the new queue and the outcome for the
+// requester. If only some of the tracks are added, the request is still considered successful.
+func enqueueTracks(queue, tracks []queuedTrack, limit int,
+	policy store.QueuePolicy) ([]queuedTrack, Response) {
+
+	requested := len(tracks)
+
+	overLimit := 0
+	if len(tracks) > limit {
+		overLimit = len(tracks) - limit
+		tracks = tracks[:limit]
+	}
+
+	added := 0
+	var rejected [queueTooLong + 1]int
+	for _, track := range tracks {
+		r := checkTrack(policy, queue, track)
+		if r != accepted {
+			rejected[r]++
+			if requested == 1 {
+				return queue, rejectionReply(policy, track, r)
+			}
+			continue
+		}
+		queue = append(queue, track)
+		added++
+	}
+
+	if requested == 1 {
+		return queue, trackReply("Song added to queue.", tracks[0])
+	}
+
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "%d of %d songs added to queue.", added, requested)
+	// If nothing was added, the request is reported as failing for the first reason checked which
+	// rejected any of the tracks.
+	status, kind := StatusRejected, ErrPolicy
+	for r := len(rejected) - 1; r > int(accepted); r-- {
+		if rejected[r] > 0 {
+			status, kind = rejectionStatus(rejection(r))
+		}
+	}
+	for r, n := range rejected {
+		if n > 0 {
+			sb.WriteString(rejectionSummary(policy, rejection(r), n))
+		}
+	}
+	if overLimit > 0 {
+		_, _ = fmt.Fprintf(&sb, " %d skipped for exceeding the limit of %d per request.",
+			overLimit, limit)
+	}
+	if added == 0 {
+		return queue, errReply(status, kind, sb.String())
+	}
+	return queue, okReply(sb.String())
+}
+
 type queueConfig struct {
-	requestChan      <-chan songReq
-	nextSong         chan *yt.Video
-	inspectSongQueue chan chan []*yt.Video
-	shutdown         chan chan []*yt.Video
+	requestChan <-chan songReq
+	nextSong    chan queuedTrack
+	// upcoming receives the song which will be played next whenever it changes, or a zero
+	// queuedTrack if there is none. Only the most recent song is kept.
+	upcoming         chan queuedTrack
+	inspectSongQueue chan chan queueSnapshot
+	edit             chan queueEdit
+	shutdown         chan chan []queuedTrack
 	firstSongWait    chan bool
+
+	// ctx is cancelled to abort any request which is currently being resolved, such as when
+	// the player is disconnecting.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	guildID string
+	store   store.Store
+	events  *eventBus
 }
 
-func newSongQueue(requestChan <-chan songReq) queueConfig {
+// newSongQueue starts a song queue goroutine. If waitFirst is true, the outcome of the first
+// request is sent on firstSongWait, and the queue shuts down if it fails.
+func newSongQueue(guildID string, requestChan <-chan songReq, st store.Store, events *eventBus,
+	waitFirst bool) queueConfig {
+	ctx, cancel := context.WithCancel(context.Background())
 	s := queueConfig{
+		guildID:          guildID,
+		store:            st,
+		events:           events,
 		requestChan:      requestChan,
-		nextSong:         make(chan *yt.Video),
-		inspectSongQueue: make(chan chan []*yt.Video),
-		shutdown:         make(chan chan []*yt.Video),
+		nextSong:         make(chan queuedTrack),
+		upcoming:         make(chan queuedTrack, 1),
+		inspectSongQueue: make(chan chan queueSnapshot),
+		edit:             make(chan queueEdit),
+		shutdown:         make(chan chan []queuedTrack),
 		firstSongWait:    make(chan bool),
+		ctx:              ctx,
+		cancel:           cancel,
 	}
-	go songQueue(s)
+	go songQueue(s, waitFirst)
 
 	return s
 }
 
-func songQueue(config queueConfig) {
-	client := yt.Client{}
-	first := true
+func songQueue(config queueConfig, first bool) {
 	success := false
 
-	var songQueue []*yt.Video
-	nullQ := make(chan *yt.Video)
-	var songChannel *chan *yt.Video
+	var songQueue []queuedTrack
+	nullQ := make(chan queuedTrack)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var songChannel *chan queuedTrack
+
+	// current is the song most recently sent to the player, which is the song that has just
+	// finished by the time the player asks for another.
+	var current *queuedTrack
+	mode := loopOff
+
+	// serial is the serial of the most recently queued song, and announced is the serial of the
+	// song most recently sent on upcoming.
+	var serial, announced uint64
+
+	// resolved receives the outcome of the request being resolved, if resolving is true. Requests
+	// are resolved one at a time, so that songs are queued in the order they were requested.
+	resolved := make(chan resolvedReq, 1)
+	resolving := false
+
 	log.Info().Msg("Song queue ready")
 	for {
-		var nextSong *yt.Video
+		var nextSong queuedTrack
+		// fromQueue is true if nextSong is taken from the head of the queue, rather than being a
+		// repeat of the current song.
+		fromQueue := false
 		// This if statement prevents the sending of songs to the player routine if there are no
-		// songs in the queue.
+		// songs to play.
 		// It sets the channel to a channel which blocks forever,
-		// and the song to be sent is a nil pointer.
-		if len(songQueue) == 0 {
-			songChannel = &nullQ
-		} else {
+		// and the song to be sent is empty.
+		switch {
+		case current != nil && mode == loopTrack:
+			nextSong = *current
+			songChannel = &config.nextSong
+		case len(songQueue) > 0:
 			nextSong = songQueue[0]
+			fromQueue = true
+			songChannel = &config.nextSong
+		case current != nil && mode == loopQueue:
+			nextSong = *current
 			songChannel = &config.nextSong
+		default:
+			songChannel = &nullQ
 		}
 
-		select {
-		case song := <-config.requestChan:
+		if nextSong.serial != announced {
+			// Replace the previous announcement if the player has not yet received it. This
+			// goroutine is the only sender, so the send cannot block.
+			select {
+			case <-config.upcoming:
+			default:
+			}
+			config.upcoming <- nextSong
+			announced = nextSong.serial
+		}
+
+		requests := config.requestChan
+		if resolving {
+			requests = nil
+		}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Second)
-			vid, err := client.GetVideoContext(ctx, song.URL)
-			cancel()
+		select {
+		case song := <-requests:
+			resolving = true
+			go resolveRequest(config.ctx, config.store, config.guildID, song, resolved)
+
+		case r := <-resolved:
+			resolving = false
+			song, tracks, failed, policy, err := r.req, r.tracks, r.failed, r.policy, r.err
+			// Only a song which will be played immediately resumes part way through.
+			if song.restore && len(tracks) > 0 && (current != nil || len(songQueue) > 0) {
+				tracks[0].start = 0
+			}
 
+			if err == nil && len(tracks) == 0 {
+				err = ErrNoResults
+			}
+			for i := range tracks {
+				serial++
+				tracks[i].serial = serial
+			}
 			if err != nil {
 				log.Error().Err(err).Msg("")
-				go trySend(song.returnChan, "Song not found.", stdTimeout)
+				var reply Response
+				switch {
+				case errors.Is(err, context.Canceled):
+					reply = errReply(StatusFailed, err, "Request cancelled.")
+				case errors.Is(err, ErrPolicy):
+					reply = errReply(StatusRejected, err,
+						"Songs cannot be requested from this channel.")
+				case errors.Is(err, sql.ErrNoRows) && song.restore:
+					reply = errReply(StatusNotFound, ErrNotFound, "No saved queue.")
+				case errors.Is(err, sql.ErrNoRows) && song.replay > 0:
+					reply = errReply(StatusNotFound, ErrNotFound,
+						"No song at that position in the history.")
+				case errors.Is(err, sql.ErrNoRows):
+					reply = errReply(StatusNotFound, ErrNotFound, "No such playlist.")
+				case errors.Is(err, ErrNoResults), errors.Is(err, ErrNoSource):
+					reply = errReply(StatusNotFound, err, "Song not found.")
+				default:
+					reply = errReply(StatusFailed, err, "Song not found.")
+				}
+				go trySend(song.returnChan, reply, stdTimeout)
 				if first {
 					config.firstSongWait <- success
 					close(config.firstSongWait)
@@ -408,13 +833,27 @@ func songQueue(config queueConfig) {
 				break
 			}
 
-			if vid.Duration <= time.Hour {
-				songQueue = append(songQueue, vid)
-				go trySend(song.returnChan, "Song added to queue.", stdTimeout)
-				success = true
-			} else {
-				log.Error().Msg(fmt.Sprintf("Song duration: %v", vid.Duration))
-				go trySend(song.returnChan, "Song too long.", stdTimeout)
+			queued := len(songQueue)
+			var reply Response
+			limit := PlaylistLimit()
+			if song.restore {
+				limit = maxQueueLength
+			}
+			songQueue, reply = enqueueTracks(songQueue, tracks, limit, policy)
+			success = success || len(songQueue) > queued
+			if len(songQueue) > queued {
+				config.events.publish(queueEvent(config.guildID, songQueue))
+			}
+			if failed > 0 {
+				reply.Message += fmt.Sprintf(" %d songs from the playlist could not be found.", failed)
+			}
+			go trySend(song.returnChan, reply, stdTimeout)
+
+			if song.restore {
+				err := config.store.DeleteQueue(config.guildID)
+				if err != nil {
+					log.Error().Err(err).Msg("")
+				}
 			}
 
 			if first {
@@ -424,24 +863,99 @@ func songQueue(config queueConfig) {
 			}
 
 		case *songChannel <- nextSong:
-			songQueue = songQueue[1:]
+			if fromQueue {
+				songQueue = songQueue[1:]
+				// When looping the queue, the finished song goes to the back of the queue.
+				if mode == loopQueue && current != nil {
+					songQueue = append(songQueue, *current)
+				}
+				config.events.publish(queueEvent(config.guildID, songQueue))
+			}
+			// Only the first play of a restored song resumes part way through, and repeats start
+			// from the beginning.
+			nextSong.start = 0
+			current = &nextSong
 		case ret := <-config.inspectSongQueue:
 			// This is slightly confusing. We do this rather than just sending directly on the
 			// channel so that we avoid data races and also only copy when required.
-			q := make([]*yt.Video, len(songQueue))
+			q := make([]queuedTrack, len(songQueue))
 			copy(q, songQueue)
 			// This is a blocking send. The receiver must listen immediately or be put to death.
-			ret <- q
+			ret <- queueSnapshot{tracks: q, loop: mode}
+		case edit := <-config.edit:
+			var reply Response
+			if edit.action == loop {
+				mode = edit.loop
+				reply = okReply(fmt.Sprintf("Loop mode set to %v.", mode))
+			} else {
+				songQueue, reply = edit.apply(songQueue, rng)
+				if reply.Status == StatusOK {
+					config.events.publish(queueEvent(config.guildID, songQueue))
+				}
+			}
+			go trySend(edit.returnChan, reply, stdTimeout)
 		case sht := <-config.shutdown:
 			sht <- songQueue
+			// A request which is still being resolved is abandoned.
+			if resolving {
+				config.cancel()
+				r := <-resolved
+				go trySend(r.req.returnChan, errReply(StatusFailed, context.Canceled,
+					"Request cancelled."), stdTimeout)
+			}
 			return
 		}
 	}
 
 }
 
+// resolvedReq is the outcome of resolving a song request.
+type resolvedReq struct {
+	req    songReq
+	tracks []queuedTrack
+	// failed is the number of songs in a playlist which could not be loaded.
+	failed int
+	policy store.QueuePolicy
+	err    error
+}
+
+// resolveRequest resolves req into the songs it asks for, sending the outcome on result. It runs
+// outside the queue goroutine, so that the queue can still be inspected and edited while a long
+// request such as a playlist is resolved. Cancelling ctx abandons the request.
+func resolveRequest(ctx context.Context, st store.Store, guildID string, req songReq,
+	result chan<- resolvedReq) {
+
+	ctx, cancel := context.WithTimeout(ctx, 500*time.Second)
+	defer cancel()
+
+	r := resolvedReq{req: req}
+	// A restored queue was already accepted when it was first requested, so it is only subject
+	// to the queue length limit.
+	if !req.restore {
+		r.policy = loadQueuePolicy(st, guildID)
+	}
+	switch {
+	case !channelAllowed(r.policy, req.channelID):
+		r.err = ErrPolicy
+	case req.restore:
+		r.tracks, r.err = loadSavedQueue(st, guildID)
+	case req.playlistName != "":
+		r.tracks, r.failed, r.err = playlistTracks(ctx, st, req.playlistOwner, req.playlistName,
+			req.requester)
+	case req.replay > 0:
+		r.tracks, r.err = replayTrack(st, guildID, req.replay, req.requester)
+	default:
+		var resolved []Track
+		resolved, r.err = resolve(ctx, req.URL)
+		for _, v := range resolved {
+			r.tracks = append(r.tracks, queuedTrack{Track: v, requester: req.requester})
+		}
+	}
+	result <- r
+}
+
 // trySend attempts to send "data" on "channel", timing out after "timeoutDuration".
-func trySend(channel chan string, data string, timeoutDuration time.Duration) {
+func trySend(channel chan Response, data Response, timeoutDuration time.Duration) {
 	// this will sure lend itself to generics when the time comes.
 	timeout := time.NewTimer(timeoutDuration)
 
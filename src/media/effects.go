@@ -0,0 +1,137 @@
+package media
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// bassBoostFilter raises the gain of low frequencies.
+	bassBoostFilter = "bass=g=10"
+	// nightcoreFactor is the factor by which the nightcore effect raises speed and pitch.
+	nightcoreFactor = 1.25
+	minEffectFactor = 0.5
+	maxEffectFactor = 2.0
+	// sampleRate is the sample rate of the audio produced by the encoder.
+	sampleRate = 48000
+)
+
+// audioEffects are the effects applied to songs played by a guild's player.
+type audioEffects struct {
+	bassBoost bool
+	// speed is the factor by which playback speed is changed, and pitch the factor by which
+	// pitch is changed. Both are 1 when unchanged.
+	speed float64
+	pitch float64
+}
+
+var noEffects = audioEffects{speed: 1, pitch: 1}
+
+// tempo returns the factor by which the effects change the speed of playback, so that song
+// positions can be converted to and from playback time.
+func (e audioEffects) tempo() float64 {
+	return e.speed
+}
+
+func (e audioEffects) String() string {
+	var names []string
+	if e.bassBoost {
+		names = append(names, "bassboost")
+	}
+	if e.speed == nightcoreFactor && e.pitch == nightcoreFactor {
+		names = append(names, "nightcore")
+	} else {
+		if e.speed != 1 {
+			names = append(names, fmt.Sprintf("speed %.2fx", e.speed))
+		}
+		if e.pitch != 1 {
+			names = append(names, fmt.Sprintf("pitch %.2fx", e.pitch))
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// filters returns the ffmpeg audio filters which apply the effects.
+func (e audioEffects) filters() []string {
+	var filters []string
+	if e.bassBoost {
+		filters = append(filters, bassBoostFilter)
+	}
+	if e.pitch != 1 {
+		// Changing the sample rate changes both pitch and speed, so the tempo is corrected
+		// afterwards.
+		filters = append(filters,
+			fmt.Sprintf("aresample=%d", sampleRate),
+			fmt.Sprintf("asetrate=%d", int(sampleRate*e.pitch)),
+			fmt.Sprintf("aresample=%d", sampleRate),
+		)
+	}
+	if tempo := e.speed / e.pitch; tempo != 1 {
+		filters = append(filters, atempoFilters(tempo)...)
+	}
+	return filters
+}
+
+// atempoFilters returns a chain of atempo filters which change tempo by factor, as a single
+// atempo filter only accepts factors between 0.5 and 2.
+func atempoFilters(factor float64) []string {
+	var filters []string
+	for factor > maxEffectFactor {
+		filters = append(filters, "atempo=2.0")
+		factor /= 2
+	}
+	for factor < minEffectFactor {
+		filters = append(filters, "atempo=0.5")
+		factor *= 2
+	}
+	return append(filters, fmt.Sprintf("atempo=%.4f", factor))
+}
+
+// apply applies a filter command to the effects, returning the new effects, whether they were
+// changed, and the outcome for the user.
+func (e audioEffects) apply(data string) (audioEffects, bool, Response) {
+	args := strings.Fields(strings.ToLower(data))
+	if len(args) == 0 {
+		return e, false, okReply(fmt.Sprintf("Filters: %v.", e))
+	}
+
+	const syntax = "Filter must be one of bassboost, nightcore, speed <factor>, pitch <factor> " +
+		"or off."
+
+	switch {
+	case args[0] == "off" && len(args) == 1:
+		e = noEffects
+
+	case args[0] == "bassboost" && len(args) == 1:
+		e.bassBoost = !e.bassBoost
+
+	case args[0] == "nightcore" && len(args) == 1:
+		if e.speed == nightcoreFactor && e.pitch == nightcoreFactor {
+			e.speed, e.pitch = 1, 1
+		} else {
+			e.speed, e.pitch = nightcoreFactor, nightcoreFactor
+		}
+
+	case (args[0] == "speed" || args[0] == "pitch") && len(args) == 2:
+		f, err := strconv.ParseFloat(strings.TrimSuffix(args[1], "x"), 64)
+		if err != nil || f < minEffectFactor || f > maxEffectFactor {
+			return e, false, errReply(StatusInvalid, ErrInvalidRequest,
+				fmt.Sprintf("Factor must be between %.1f and %.1f.", minEffectFactor,
+					maxEffectFactor))
+		}
+		if args[0] == "speed" {
+			e.speed = f
+		} else {
+			e.pitch = f
+		}
+
+	default:
+		return e, false, errReply(StatusInvalid, ErrInvalidRequest, syntax)
+	}
+
+	return e, true, okReply(fmt.Sprintf("Filters set to %v.", e))
+}
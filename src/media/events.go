@@ -0,0 +1,99 @@
+package media
+
+import (
+	"sync"
+
+	"github.com/dpatterbee/strife/src/store"
+)
+
+//go:generate stringer -type=EventType
+
+// EventType is the kind of an Event.
+type EventType int
+
+const (
+	// EventTrackStarted means a song started playing.
+	EventTrackStarted EventType = iota
+	// EventTrackEnded means a song stopped playing, because it finished, was skipped or the player
+	// shut down.
+	EventTrackEnded
+	// EventQueueChanged means songs were added to, removed from or moved within the queue.
+	EventQueueChanged
+	// EventPlayerStopped means the player left its voice channel.
+	EventPlayerStopped
+	// EventTrackFailed means a song could not be played, as it could not be opened. It is not
+	// recorded in the play history.
+	EventTrackFailed
+)
+
+// Event is something which happened to a guild's player, sent to the subscribers of the Controller.
+type Event struct {
+	Type    EventType
+	GuildID string
+	// Track is the song the event concerns, for EventTrackStarted, EventTrackEnded and
+	// EventTrackFailed. Skipped is true if an EventTrackEnded was caused by a skip.
+	Track   *store.TrackRef
+	Skipped bool
+	// Err is why the song could not be played, for EventTrackFailed.
+	Err error
+	// Queue holds the songs waiting to be played, for EventQueueChanged.
+	Queue []store.TrackRef
+}
+
+// eventBus sends Events to every subscriber.
+type eventBus struct {
+	subscribers map[chan Event]bool
+	sync.Mutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan Event]bool)}
+}
+
+// subscribe returns a new subscription whose channel can hold buffer events.
+func (b *eventBus) subscribe(buffer int) chan Event {
+	ch := make(chan Event, buffer)
+	b.Lock()
+	b.subscribers[ch] = true
+	b.Unlock()
+	return ch
+}
+
+// unsubscribe ends a subscription, closing its channel.
+func (b *eventBus) unsubscribe(ch chan Event) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish sends e to every subscriber. Subscribers whose buffers are full miss the event, so that
+// a slow subscriber cannot hold up playback.
+func (b *eventBus) publish(e Event) {
+	b.Lock()
+	defer b.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// queueEvent returns an EventQueueChanged for the guild's queue.
+func queueEvent(guildID string, queue []queuedTrack) Event {
+	e := Event{Type: EventQueueChanged, GuildID: guildID, Queue: make([]store.TrackRef, 0,
+		len(queue))}
+	for _, v := range queue {
+		e.Queue = append(e.Queue, trackRef(v))
+	}
+	return e
+}
+
+// trackEvent returns an event of type t concerning song.
+func trackEvent(t EventType, guildID string, song queuedTrack) Event {
+	ref := trackRef(song)
+	return Event{Type: t, GuildID: guildID, Track: &ref}
+}
@@ -0,0 +1,46 @@
+package media
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+// recordPlay records that song has started playing in the guild, returning the ID of the record or
+// zero if it could not be recorded.
+func recordPlay(st store.Store, guildID string, song queuedTrack) int64 {
+	id, err := st.AddPlay(guildID, store.Play{Track: trackRef(song), Started: time.Now()})
+	if err != nil {
+		log.Error().Err(err).Str("guildID", guildID).Msg("Couldn't record play")
+		return 0
+	}
+	return id
+}
+
+// finishPlay records that the song with the given play record has stopped playing.
+func finishPlay(st store.Store, playID int64, skipped bool) {
+	if playID == 0 {
+		return
+	}
+	err := st.FinishPlay(playID, time.Now(), skipped)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't record end of play")
+	}
+}
+
+// replayTrack returns the nth most recently played track in the guild, starting from 1.
+func replayTrack(st store.Store, guildID string, n int, requester string) ([]queuedTrack, error) {
+	plays, err := st.GetHistory(guildID, n)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || len(plays) < n {
+		return nil, sql.ErrNoRows
+	}
+
+	ref := plays[n-1].Track
+	ref.Requester = requester
+	return []queuedTrack{{Track: &storedTrack{ref: ref}, requester: requester}}, nil
+}
@@ -0,0 +1,241 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const librarySourceName = "local"
+
+// libraryIndexInterval is how often the index of the files in a library is rebuilt, so that files
+// added or removed while the bot is running are found.
+const libraryIndexInterval = 10 * time.Minute
+
+// libraryExtensions are the file extensions which are considered to be playable audio files.
+var libraryExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".wav":  true,
+}
+
+// ErrNoAudioFiles is the error used when a library directory contains no playable files
+var ErrNoAudioFiles = errors.New("no audio files found")
+
+// librarySource resolves paths and names of audio files in an on-disk media library.
+type librarySource struct {
+	root string
+
+	// names maps the lower case name of every audio file in the library, without its extension,
+	// to its path, so that requests by name do not need to search the library.
+	names map[string]string
+	sync.RWMutex
+}
+
+// NewLibrarySource returns a Source which plays audio files from the directory root.
+// Requests may be either a path relative to root, which may refer to a directory other than root
+// itself, or the name of a file without its extension.
+func NewLibrarySource(root string) Source {
+	l := &librarySource{root: filepath.Clean(root)}
+	go l.indexLoop()
+	return l
+}
+
+func (*librarySource) Name() string {
+	return librarySourceName
+}
+
+func (l *librarySource) Accepts(query string) bool {
+	_, ok := l.lookup(query)
+	return ok
+}
+
+func (l *librarySource) Resolve(ctx context.Context, query string) ([]Track, error) {
+	path, ok := l.lookup(query)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		track, err := l.newTrack(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return []Track{track}, nil
+	}
+
+	var paths []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && isAudioFile(p) {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var tracks []Track
+	for _, p := range paths {
+		track, err := l.newTrack(ctx, p)
+		if err != nil {
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+	if len(tracks) == 0 {
+		return nil, ErrNoAudioFiles
+	}
+
+	return tracks, nil
+}
+
+// lookup finds the file or directory in the library referred to by query.
+// The query is first treated as a path relative to the library root, and then as the name of a
+// file without its extension.
+func (l *librarySource) lookup(query string) (string, bool) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", false
+	}
+
+	// Cleaning the query as an absolute path prevents it from escaping the library root. The
+	// root itself cannot be requested, as it would queue the entire library.
+	path := filepath.Join(l.root, filepath.Clean("/"+query))
+	if path != l.root {
+		if info, err := os.Stat(path); err == nil && (info.IsDir() || isAudioFile(path)) {
+			return path, true
+		}
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+	found, ok := l.names[strings.ToLower(query)]
+	return found, ok
+}
+
+// indexLoop indexes the library, and then indexes it again every libraryIndexInterval.
+func (l *librarySource) indexLoop() {
+	ticker := time.NewTicker(libraryIndexInterval)
+	defer ticker.Stop()
+	for {
+		l.index()
+		<-ticker.C
+	}
+}
+
+// index rebuilds the index of the names of the files in the library.
+func (l *librarySource) index() {
+	names := make(map[string]string)
+	_ = filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isAudioFile(p) {
+			return nil
+		}
+		// If several files share a name, the first found is played.
+		name := strings.ToLower(strings.TrimSuffix(d.Name(), filepath.Ext(d.Name())))
+		if _, ok := names[name]; !ok {
+			names[name] = p
+		}
+		return nil
+	})
+
+	l.Lock()
+	l.names = names
+	l.Unlock()
+}
+
+func (l *librarySource) newTrack(ctx context.Context, path string) (*libraryTrack, error) {
+	duration, err := probeDuration(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := filepath.Rel(l.root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &libraryTrack{
+		id:       id,
+		path:     path,
+		title:    strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		duration: duration,
+	}, nil
+}
+
+func isAudioFile(path string) bool {
+	return libraryExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// probeDuration uses ffprobe to find the duration of the audio file at path.
+func probeDuration(ctx context.Context, path string) (time.Duration, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json",
+		"-show_format", path).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	err = json.Unmarshal(out, &probe)
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+type libraryTrack struct {
+	id       string
+	path     string
+	title    string
+	duration time.Duration
+}
+
+func (t *libraryTrack) ID() string {
+	return t.id
+}
+
+func (t *libraryTrack) Title() string {
+	return t.title
+}
+
+func (t *libraryTrack) Duration() time.Duration {
+	return t.duration
+}
+
+func (t *libraryTrack) Source() string {
+	return librarySourceName
+}
+
+func (t *libraryTrack) Open(context.Context) (io.ReadCloser, error) {
+	return os.Open(t.path)
+}
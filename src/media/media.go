@@ -1,17 +1,22 @@
 package media
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/store"
 )
 
 // Controller represents an active media controller.
 type Controller struct {
-	rch     chan Request
-	session *discordgo.Session
-	active  bool
+	rch      chan Request
+	voice    chan string
+	shutdown chan chan bool
+	events   *eventBus
+	session  *discordgo.Session
+	active   bool
 }
 
 //go:generate stringer -type=Action
@@ -24,15 +29,55 @@ const (
 	SKIP
 	DISCONNECT
 	INSPECT
+	SEEK
+	FORWARD
+	REWIND
+	REMOVE
+	MOVE
+	SHUFFLE
+	CLEAR
+	LOOP
+	RESTORE
+	SAVEPLAYLIST
+	LOADPLAYLIST
+	REPLAY
+	NOWPLAYING
+	VOLUME
+	NORMALIZE
+	FILTER
+	SUMMON
+	SOUNDBOARD
+	SAY
+	RECORD
 )
 
+// User identifies the user making a Request.
+type User struct {
+	ID string
+	// Privileged is true if the user may modify songs requested by other users.
+	Privileged bool
+}
+
 // Request contains the fields required to communicate an intention to the media controller.
+// ChannelID is the voice channel of the user making the request, or empty for requests which can be
+// made from outside of a voice channel. Requests to a guild's active player are rejected if they
+// are made from a different channel, unless the user is privileged.
+// For SAVEPLAYLIST and LOADPLAYLIST, CommandData is the ID of the playlist's owner followed by a
+// space and the name of the playlist. For REPLAY, CommandData is the position in the guild's play
+// history of the song to be played again, starting from 1 for the most recent. For VOLUME,
+// CommandData is a percentage from 0 to 200, and for NORMALIZE it is "on" or "off". Either reports
+// the current setting if CommandData is empty. For FILTER, CommandData is one of "bassboost",
+// "nightcore", "speed <factor>", "pitch <factor>" or "off". For SOUNDBOARD, CommandData is the name
+// of a clip on the guild's soundboard, and for SAY it is the text to be spoken. For RECORD,
+// CommandData is "start" followed by a space and the ID of the text channel the recording is
+// reported to, or "stop".
 type Request struct {
 	CommandType Action
 	GuildID     string
 	ChannelID   string
+	User        User
 	CommandData string
-	ReturnChan  chan string
+	ReturnChan  chan Response
 }
 
 // ErrNotActive is the error used when the Controller is not active
@@ -41,46 +86,99 @@ var ErrNotActive = errors.New("controller inactive")
 // ErrServerBusy is the error used when the controller takes too long to accept a new command
 var ErrServerBusy = errors.New("server busy")
 
-// New returns a new media.Controller
-func New(s *discordgo.Session) Controller {
+// New returns a new media.Controller. Queues are saved to st when a player shuts down with songs
+// remaining.
+func New(s *discordgo.Session, st store.Store) Controller {
 	ch := make(chan Request)
+	voice := make(chan string)
+	shutdown := make(chan chan bool)
+	events := newEventBus()
 
-	go controller(s, ch)
+	go controller(s, st, events, ch, voice, shutdown)
+
+	return Controller{rch: ch, voice: voice, shutdown: shutdown, events: events, session: s,
+		active: true}
+}
+
+// Subscribe returns a channel on which the Events of every guild's player are sent, which can hold
+// up to buffer Events, along with a function which ends the subscription and closes the channel.
+// Events are dropped rather than waited for if the channel is full.
+func (c Controller) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := c.events.subscribe(buffer)
+	return ch, func() {
+		c.events.unsubscribe(ch)
+	}
+}
+
+// Shutdown disconnects every active player, saving their queues, and waits up to timeout for them
+// to finish.
+func (c Controller) Shutdown(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	done := make(chan bool)
+
+	select {
+	case c.shutdown <- done:
+	case <-timer.C:
+		return ErrServerBusy
+	}
+
+	select {
+	case <-done:
+		timer.Stop()
+		return nil
+	case <-timer.C:
+		return ErrServerBusy
+	}
+}
 
-	return Controller{rch: ch, session: s, active: true}
+// VoiceStateUpdate informs the Controller that the members of a voice channel in the guild have
+// changed, so that a player left alone in its channel can pause and leave.
+func (c Controller) VoiceStateUpdate(guildID string) {
+	if !c.active {
+		return
+	}
+	timeout := time.NewTimer(stdTimeout)
+	select {
+	case c.voice <- guildID:
+		timeout.Stop()
+	case <-timeout.C:
+	}
 }
 
-// Send sends a Request to the Controller
-func (c Controller) Send(guildID, channelID string, commandType Action,
-	commandData string) (string, error) {
+// Send sends a Request to the Controller and waits for its Response. If the request cannot be
+// delivered before ctx is done, ErrServerBusy is returned, or the context's error if it was
+// cancelled. If it is delivered but no reply arrives before ctx is done, the Response has
+// StatusTimeout, as the request may still be carried out.
+func (c Controller) Send(ctx context.Context, guildID, channelID string, user User,
+	commandType Action, commandData string) (Response, error) {
 
-	timeout := time.NewTimer(5 * time.Second)
-	retchan := make(chan string)
+	retchan := make(chan Response)
 
 	req := Request{
 		CommandType: commandType,
 		GuildID:     guildID,
 		ChannelID:   channelID,
+		User:        user,
 		CommandData: commandData,
 		ReturnChan:  retchan,
 	}
 
 	if !c.active {
-		return "", ErrNotActive
+		return Response{}, ErrNotActive
 	}
 	select {
 	case c.rch <- req:
-	case <-timeout.C:
-		return "", ErrServerBusy
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return Response{}, ErrServerBusy
+		}
+		return Response{}, ctx.Err()
 	}
 
 	select {
-	case s := <-retchan:
-		timeout.Stop()
-		return s, nil
-	case <-timeout.C:
-		return "Request sent", nil
-
+	case r := <-retchan:
+		return r, nil
+	case <-ctx.Done():
+		return Response{Status: StatusTimeout, Err: ctx.Err(), Message: "Request sent."}, nil
 	}
-
 }
@@ -0,0 +1,57 @@
+package media
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// progressBarWidth is the number of characters in the progress bar shown for the current song.
+const progressBarWidth = 20
+
+// describeCurrentSong describes the song being played by m, and how far through it playback has
+// reached. The requester of the song is given by the Track of the Response.
+func describeCurrentSong(song queuedTrack, m *mediaSession) Response {
+	var sb strings.Builder
+	elapsed := m.stream.PlaybackPos()
+
+	_, _ = fmt.Fprintf(&sb, "Now playing: %s\n", song.Title())
+
+	if song.Duration() == 0 {
+		_, _ = fmt.Fprintf(&sb, "%s (live)", formatClock(elapsed))
+	} else {
+		_, _ = fmt.Fprintf(&sb, "%s %s / %s", progressBar(elapsed, song.Duration()),
+			formatClock(elapsed), formatClock(song.Duration()))
+	}
+	if m.stream.Paused() {
+		sb.WriteString(" (paused)")
+	}
+
+	r := trackReply(sb.String(), song)
+	r.Position = elapsed
+	return r
+}
+
+// progressBar returns a text bar showing how much of total has elapsed.
+func progressBar(elapsed, total time.Duration) string {
+	pos := int(int64(progressBarWidth) * int64(elapsed) / int64(total))
+	if pos < 0 {
+		pos = 0
+	}
+	if pos >= progressBarWidth {
+		pos = progressBarWidth - 1
+	}
+	return "[" + strings.Repeat("=", pos) + "o" + strings.Repeat("-", progressBarWidth-pos-1) + "]"
+}
+
+// formatClock formats d in the form "h:mm:ss", or "m:ss" if it is shorter than an hour.
+func formatClock(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	sec := int(d % time.Minute / time.Second)
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
@@ -0,0 +1,117 @@
+package media
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+// storedTrack is a Track recreated from a store.TrackRef. It is resolved again by its source
+// only once it is opened, so that restoring a large queue or loading a playlist does not require
+// resolving every song.
+type storedTrack struct {
+	ref store.TrackRef
+}
+
+func (t *storedTrack) ID() string {
+	return t.ref.ID
+}
+
+func (t *storedTrack) Title() string {
+	return t.ref.Title
+}
+
+func (t *storedTrack) Duration() time.Duration {
+	return t.ref.Duration
+}
+
+func (t *storedTrack) Source() string {
+	return t.ref.Source
+}
+
+func (t *storedTrack) Open(ctx context.Context) (io.ReadCloser, error) {
+	r, err := t.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Open(ctx)
+}
+
+// resolve resolves the track again by its source.
+func (t *storedTrack) resolve(ctx context.Context) (Track, error) {
+	s := sourceByName(t.ref.Source)
+	if s == nil {
+		return nil, ErrNoSource
+	}
+
+	tracks, err := s.Resolve(ctx, t.ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, ErrNoResults
+	}
+	return tracks[0], nil
+}
+
+// trackRef returns a reference to t which can be persisted.
+func trackRef(t queuedTrack) store.TrackRef {
+	return store.TrackRef{
+		Source:    t.Source(),
+		ID:        t.ID(),
+		Title:     t.Title(),
+		Duration:  t.Duration(),
+		Requester: t.requester,
+	}
+}
+
+// loadSavedQueue returns the saved queue of the guild as queued tracks, with the first track set
+// to start where it was interrupted.
+func loadSavedQueue(st store.Store, guildID string) ([]queuedTrack, error) {
+	saved, err := st.GetQueue(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]queuedTrack, 0, len(saved.Tracks))
+	for _, v := range saved.Tracks {
+		tracks = append(tracks, queuedTrack{Track: &storedTrack{ref: v}, requester: v.Requester})
+	}
+	if len(tracks) > 0 {
+		tracks[0].start = saved.Position
+	}
+
+	return tracks, nil
+}
+
+// saveQueue persists the song which was interrupted when the player shut down, if any, followed
+// by the remaining queue, along with why the player shut down. If there is nothing left to play,
+// any previously saved queue is removed.
+func saveQueue(st store.Store, guildID, channelID string, reason store.SaveReason,
+	interrupted *queuedTrack, queue []queuedTrack) {
+
+	saved := store.SavedQueue{ChannelID: channelID, Reason: reason}
+	if interrupted != nil {
+		saved.Tracks = append(saved.Tracks, trackRef(*interrupted))
+		// The position in a live stream cannot be returned to.
+		if interrupted.Duration() != 0 {
+			saved.Position = interrupted.start
+		}
+	}
+	for _, v := range queue {
+		saved.Tracks = append(saved.Tracks, trackRef(v))
+	}
+
+	var err error
+	if len(saved.Tracks) == 0 {
+		err = st.DeleteQueue(guildID)
+	} else {
+		err = st.SaveQueue(guildID, saved)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("guildID", guildID).Msg("Couldn't save queue")
+	}
+}
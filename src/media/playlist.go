@@ -0,0 +1,121 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+// splitPlaylistData splits the command data of a playlist Request into the owner ID and the
+// playlist name.
+func splitPlaylistData(data string) (ownerID, name string) {
+	split := strings.SplitN(data, " ", 2)
+	if len(split) < 2 {
+		return split[0], ""
+	}
+	return split[0], split[1]
+}
+
+// Lookup resolves query and returns references to the resulting tracks, suitable for saving in a
+// playlist.
+func Lookup(ctx context.Context, query string) ([]store.TrackRef, error) {
+	tracks, err := resolve(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]store.TrackRef, 0, len(tracks))
+	for _, v := range tracks {
+		refs = append(refs, trackRef(queuedTrack{Track: v}))
+	}
+	return refs, nil
+}
+
+// playlistResolvers is how many songs of a playlist are resolved at once when it is loaded.
+const playlistResolvers = 4
+
+// playlistTracks returns the tracks of the named playlist, along with the number which cannot be
+// played, because their source is no longer registered or they no longer resolve. Songs which are
+// cached are not resolved, as they can be played without their source.
+func playlistTracks(ctx context.Context, st store.Store, ownerID, name,
+	requester string) ([]queuedTrack, int, error) {
+
+	refs, err := st.GetPlaylist(ownerID, name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// resolved holds the track for each song, which is nil if it could not be resolved.
+	resolved := make([]Track, len(refs))
+	sem := make(chan bool, playlistResolvers)
+	var wg sync.WaitGroup
+	for i, ref := range refs {
+		t := &storedTrack{ref: ref}
+		if cached(t) {
+			resolved[i] = t
+			continue
+		}
+		wg.Add(1)
+		sem <- true
+		go func(i int, t *storedTrack) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r, err := t.resolve(ctx)
+			if err != nil {
+				log.Error().Err(err).Str("ID", t.ref.ID).Str("Source", t.ref.Source).
+					Msg("Couldn't resolve playlist track")
+				return
+			}
+			resolved[i] = r
+		}(i, t)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var tracks []queuedTrack
+	failed := 0
+	for _, t := range resolved {
+		if t == nil {
+			failed++
+			continue
+		}
+		tracks = append(tracks, queuedTrack{Track: t, requester: requester})
+	}
+
+	return tracks, failed, nil
+}
+
+// storePlaylist saves the current song, if any, followed by the queue as the named playlist.
+func storePlaylist(st store.Store, data string, current *queuedTrack,
+	q []queuedTrack) Response {
+
+	ownerID, name := splitPlaylistData(data)
+	if name == "" {
+		return errReply(StatusInvalid, ErrInvalidRequest, "Playlist name required.")
+	}
+
+	var refs []store.TrackRef
+	if current != nil {
+		refs = append(refs, trackRef(*current))
+	}
+	for _, v := range q {
+		refs = append(refs, trackRef(v))
+	}
+	if len(refs) == 0 {
+		return errReply(StatusRejected, ErrNotPlaying, "Queue is empty.")
+	}
+
+	err := st.SavePlaylist(ownerID, name, refs)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return errReply(StatusFailed, err, "Couldn't save playlist.")
+	}
+
+	return okReply(fmt.Sprintf("Saved %d songs to playlist %s.", len(refs), name))
+}
@@ -0,0 +1,153 @@
+package media
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultQueuePolicy is the queue policy of guilds which have not set their own.
+var DefaultQueuePolicy = store.QueuePolicy{MaxTrackLength: time.Hour}
+
+// errChannelDenied and errSourceDenied are the errors used when a soundboard clip or speech is
+// refused by the queue policy. Both are ErrPolicy errors.
+var (
+	errChannelDenied = fmt.Errorf("%w: channel not allowed", ErrPolicy)
+	errSourceDenied  = fmt.Errorf("%w: source not allowed", ErrPolicy)
+)
+
+// loadQueuePolicy returns the queue policy of the guild, or the default if none has been set.
+func loadQueuePolicy(st store.Store, guildID string) store.QueuePolicy {
+	policy, err := st.GetQueuePolicy(guildID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Msg("")
+		}
+		return DefaultQueuePolicy
+	}
+	return policy
+}
+
+// listAllows reports whether name is permitted by an allow list and a deny list.
+func listAllows(allowed, denied []string, name string) bool {
+	for _, v := range denied {
+		if v == name {
+			return false
+		}
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, v := range allowed {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// channelAllowed reports whether songs can be requested from the voice channel.
+func channelAllowed(policy store.QueuePolicy, channelID string) bool {
+	return listAllows(policy.AllowedChannels, policy.DeniedChannels, channelID)
+}
+
+// rejection is the reason a track could not be added to the queue.
+type rejection int
+
+const (
+	accepted rejection = iota
+	queueFull
+	sourceDenied
+	trackTooLong
+	userTracksExceeded
+	queueTooLong
+)
+
+// checkTrack returns why track cannot be added to the end of queue, or accepted if it can be.
+func checkTrack(policy store.QueuePolicy, queue []queuedTrack, track queuedTrack) rejection {
+	if len(queue) >= maxQueueLength {
+		return queueFull
+	}
+	if !listAllows(policy.AllowedSources, policy.DeniedSources, track.Source()) {
+		return sourceDenied
+	}
+	// Tracks of unknown length, such as live streams, are not subject to the duration limits.
+	if policy.MaxTrackLength > 0 && track.Duration() > policy.MaxTrackLength {
+		return trackTooLong
+	}
+	if policy.MaxUserTracks > 0 {
+		n := 0
+		for _, v := range queue {
+			if v.requester == track.requester {
+				n++
+			}
+		}
+		if n >= policy.MaxUserTracks {
+			return userTracksExceeded
+		}
+	}
+	if policy.MaxQueueDuration > 0 {
+		total := track.Duration()
+		for _, v := range queue {
+			total += v.Duration()
+		}
+		if total > policy.MaxQueueDuration {
+			return queueTooLong
+		}
+	}
+	return accepted
+}
+
+// rejectionStatus returns the status and kind of error of a request refused for r.
+func rejectionStatus(r rejection) (Status, error) {
+	if r == queueFull {
+		return StatusBusy, ErrQueueFull
+	}
+	return StatusRejected, ErrPolicy
+}
+
+// rejectionReply tells the requester why track could not be added to the queue.
+func rejectionReply(policy store.QueuePolicy, track queuedTrack, r rejection) Response {
+	var message string
+	switch r {
+	case queueFull:
+		message = "Queue full, please try again later."
+	case sourceDenied:
+		message = fmt.Sprintf("Songs from %s are not allowed.", track.Source())
+	case trackTooLong:
+		message = fmt.Sprintf("Song too long, the limit is %v.", policy.MaxTrackLength)
+	case userTracksExceeded:
+		message = fmt.Sprintf("You can only have %d songs in the queue.", policy.MaxUserTracks)
+	case queueTooLong:
+		message = fmt.Sprintf("The queue cannot be longer than %v.", policy.MaxQueueDuration)
+	}
+	status, err := rejectionStatus(r)
+	reply := errReply(status, err, message)
+	ref := trackRef(track)
+	reply.Track = &ref
+	return reply
+}
+
+// rejectionSummary returns a sentence describing n tracks from a request being rejected for r.
+func rejectionSummary(policy store.QueuePolicy, r rejection, n int) string {
+	switch r {
+	case queueFull:
+		return fmt.Sprintf(" %d skipped because the queue is full.", n)
+	case sourceDenied:
+		return fmt.Sprintf(" %d skipped because their source is not allowed.", n)
+	case trackTooLong:
+		return fmt.Sprintf(" %d skipped for being longer than %v.", n, policy.MaxTrackLength)
+	case userTracksExceeded:
+		return fmt.Sprintf(" %d skipped for exceeding the limit of %d songs per user.", n,
+			policy.MaxUserTracks)
+	case queueTooLong:
+		return fmt.Sprintf(" %d skipped because the queue cannot be longer than %v.", n,
+			policy.MaxQueueDuration)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,64 @@
+package media
+
+import (
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+// prefetchedSong is the next song in the queue, which starts downloading and encoding while the
+// current song plays so that it can start without a gap.
+type prefetchedSong struct {
+	song    queuedTrack
+	audio   store.AudioSettings
+	effects audioEffects
+	session *mediaSession
+}
+
+// prefetch starts preparing next, replacing p, and returns the new prefetched song. Live streams
+// are not prefetched, as they would be out of date by the time they are played, and a zero next
+// cancels any prefetched song.
+func prefetch(p *prefetchedSong, next queuedTrack, vc *dgo.VoiceConnection,
+	audio store.AudioSettings, effects audioEffects) *prefetchedSong {
+
+	if p != nil && p.song.serial == next.serial {
+		return p
+	}
+	p.cancel()
+
+	if next.serial == 0 || next.Duration() == 0 {
+		return nil
+	}
+
+	session, err := newMediaSession(next, vc, next.start, audio, effects)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't prefetch song")
+		return nil
+	}
+	log.Info().Str("Title", next.Title()).Msg("Prefetching song")
+
+	return &prefetchedSong{song: next, audio: audio, effects: effects, session: session}
+}
+
+// take returns the prepared session if p is a prefetch of song with the given settings. Otherwise,
+// p is cancelled and take returns nil.
+func (p *prefetchedSong) take(song queuedTrack, audio store.AudioSettings,
+	effects audioEffects) *mediaSession {
+
+	if p == nil {
+		return nil
+	}
+	if p.song.serial != song.serial || p.song.start != song.start || p.audio != audio ||
+		p.effects != effects {
+		p.cancel()
+		return nil
+	}
+	return p.session
+}
+
+// cancel stops preparing the prefetched song.
+func (p *prefetchedSong) cancel() {
+	if p != nil {
+		p.session.stop()
+	}
+}
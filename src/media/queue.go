@@ -0,0 +1,164 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queuedTrack is a Track in a song queue, along with the ID of the user who requested it.
+type queuedTrack struct {
+	Track
+	requester string
+	// start is the position in the track at which playback begins.
+	start time.Duration
+	// serial identifies the entry in the queue, and is zero if the track has not been queued.
+	serial uint64
+}
+
+// queueSnapshot is a copy of the state of a song queue.
+type queueSnapshot struct {
+	tracks []queuedTrack
+	loop   loopMode
+}
+
+// loopMode determines what happens to a song queue once a song has finished playing.
+type loopMode int
+
+const (
+	// loopOff discards songs once they have been played.
+	loopOff loopMode = iota
+	// loopTrack repeats the current song.
+	loopTrack
+	// loopQueue moves songs to the back of the queue once they have been played.
+	loopQueue
+)
+
+var loopModeNames = []string{"off", "track", "queue"}
+
+func (l loopMode) String() string {
+	return loopModeNames[l]
+}
+
+func parseLoopMode(s string) (loopMode, error) {
+	for i, v := range loopModeNames {
+		if strings.EqualFold(strings.TrimSpace(s), v) {
+			return loopMode(i), nil
+		}
+	}
+	return loopOff, fmt.Errorf("invalid loop mode: %q", s)
+}
+
+// queueEdit is a modification to a song queue which is applied by the queue goroutine.
+// Positions are indexes into the queue, starting from zero.
+type queueEdit struct {
+	action     Action
+	from, to   int
+	loop       loopMode
+	user       User
+	returnChan chan Response
+}
+
+// newQueueEdit builds a queueEdit from a player command, parsing the 1-based queue positions given
+// in the command data.
+func newQueueEdit(control playerCommand) (queueEdit, error) {
+	edit := queueEdit{
+		action:     control.commandType,
+		user:       control.user,
+		returnChan: control.returnChannel,
+	}
+
+	if control.commandType == loop {
+		var err error
+		edit.loop, err = parseLoopMode(control.commandData)
+		return edit, err
+	}
+
+	args := strings.Fields(control.commandData)
+	var positions []int
+	for _, v := range args {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return edit, fmt.Errorf("invalid queue position: %q", v)
+		}
+		positions = append(positions, n-1)
+	}
+
+	switch control.commandType {
+	case remove:
+		if len(positions) != 1 {
+			return edit, errors.New("remove requires one position")
+		}
+		edit.from = positions[0]
+	case move:
+		if len(positions) != 2 {
+			return edit, errors.New("move requires two positions")
+		}
+		edit.from, edit.to = positions[0], positions[1]
+	}
+
+	return edit, nil
+}
+
+// canModify reports whether the user making the edit may modify the given queued track.
+func (e queueEdit) canModify(t queuedTrack) bool {
+	return e.user.Privileged || e.user.ID == t.requester
+}
+
+// apply applies the edit to queue, returning the new queue and the outcome for the user.
+func (e queueEdit) apply(queue []queuedTrack, rng *rand.Rand) ([]queuedTrack, Response) {
+	switch e.action {
+	case remove:
+		if e.from >= len(queue) {
+			return queue, errReply(StatusNotFound, ErrNotFound, "No song at that position.")
+		}
+		t := queue[e.from]
+		if !e.canModify(t) {
+			return queue, errReply(StatusRejected, ErrNotPermitted,
+				"You can only remove songs you requested.")
+		}
+		queue = append(queue[:e.from], queue[e.from+1:]...)
+		return queue, trackReply(fmt.Sprintf("Removed %s from the queue.", t.Title()), t)
+
+	case move:
+		if e.from >= len(queue) {
+			return queue, errReply(StatusNotFound, ErrNotFound, "No song at that position.")
+		}
+		t := queue[e.from]
+		if !e.canModify(t) {
+			return queue, errReply(StatusRejected, ErrNotPermitted,
+				"You can only move songs you requested.")
+		}
+		to := e.to
+		if to >= len(queue) {
+			to = len(queue) - 1
+		}
+		queue = append(queue[:e.from], queue[e.from+1:]...)
+		queue = append(queue[:to], append([]queuedTrack{t}, queue[to:]...)...)
+		return queue, trackReply(fmt.Sprintf("Moved %s to position %d.", t.Title(), to+1), t)
+
+	case shuffle:
+		rng.Shuffle(len(queue), func(i, j int) {
+			queue[i], queue[j] = queue[j], queue[i]
+		})
+		return queue, okReply("Queue shuffled.")
+
+	case clear:
+		// Users without privileges can only clear their own songs from the queue.
+		kept := queue[:0]
+		removed := 0
+		for _, t := range queue {
+			if e.canModify(t) {
+				removed++
+				continue
+			}
+			kept = append(kept, t)
+		}
+		return kept, okReply(fmt.Sprintf("Removed %d songs from the queue.", removed))
+	}
+
+	return queue, errReply(StatusInvalid, ErrInvalidRequest, "Unknown queue command.")
+}
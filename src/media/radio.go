@@ -0,0 +1,263 @@
+package media
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const httpSourceName = "http"
+
+// ErrNotAudio is the error used when a URL does not serve audio data
+var ErrNotAudio = errors.New("url is not an audio stream")
+
+// httpSource resolves plain HTTP(S) audio URLs, including Icecast and Shoutcast streams.
+type httpSource struct{}
+
+func (httpSource) Name() string {
+	return httpSourceName
+}
+
+func (httpSource) Accepts(query string) bool {
+	u, err := url.Parse(strings.TrimSpace(query))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	// YouTube pages are not audio, leave them to the YouTube source.
+	return !strings.Contains(u.Hostname(), "youtu")
+}
+
+func (httpSource) Resolve(ctx context.Context, query string) ([]Track, error) {
+	query = strings.TrimSpace(query)
+
+	resp, err := httpAudioGet(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	isIcy := resp.Header.Get("icy-metaint") != "" || resp.Header.Get("icy-name") != ""
+	if !isIcy && !strings.HasPrefix(contentType, "audio/") && contentType != "application/ogg" {
+		return nil, ErrNotAudio
+	}
+
+	t := &httpTrack{url: query}
+
+	if name := resp.Header.Get("icy-name"); name != "" {
+		t.name = name
+	} else {
+		u, _ := url.Parse(query)
+		t.name = u.Host + u.Path
+	}
+
+	// Streams without a known length are live, and keep an unknown duration.
+	if !isIcy && resp.ContentLength > 0 {
+		if d, err := probeDuration(ctx, query); err == nil {
+			t.duration = d
+		}
+	}
+
+	return []Track{t}, nil
+}
+
+// httpAudioGet requests url, asking Icecast servers to include stream metadata.
+func httpAudioGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Icy-MetaData", "1")
+
+	resp, err := icyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// icyClient is used to request audio, as it also accepts the "ICY 200 OK" status line which
+// Shoutcast v1 servers send in place of an HTTP one.
+var icyClient = &http.Client{Transport: newIcyTransport()}
+
+func newIcyTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dial := t.DialContext
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &icyConn{Conn: conn}, nil
+	}
+	return t
+}
+
+// icyConn rewrites the ICY status line at the start of a Shoutcast v1 response into an HTTP/1.0
+// one, which net/http can parse. Anything else, including TLS, is passed through unchanged.
+type icyConn struct {
+	net.Conn
+	checked bool
+	// pending holds the start of the response, once it has been checked.
+	pending []byte
+}
+
+func (c *icyConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		head := make([]byte, 4)
+		n, err := io.ReadFull(c.Conn, head)
+		if n == 0 {
+			return 0, err
+		}
+		c.pending = head[:n]
+		if string(c.pending) == "ICY " {
+			c.pending = []byte("HTTP/1.0 ")
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+type httpTrack struct {
+	url      string
+	name     string
+	duration time.Duration
+
+	// streamTitle is the most recent title received in the stream's ICY metadata.
+	streamTitle string
+	sync.RWMutex
+}
+
+func (t *httpTrack) ID() string {
+	return t.url
+}
+
+func (t *httpTrack) Title() string {
+	t.RLock()
+	defer t.RUnlock()
+	if t.streamTitle == "" {
+		return t.name
+	}
+	return t.name + " - " + t.streamTitle
+}
+
+func (t *httpTrack) Duration() time.Duration {
+	return t.duration
+}
+
+func (t *httpTrack) Source() string {
+	return httpSourceName
+}
+
+func (t *httpTrack) Open(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := httpAudioGet(ctx, t.url)
+	if err != nil {
+		return nil, err
+	}
+
+	metaInt, err := strconv.Atoi(resp.Header.Get("icy-metaint"))
+	if err != nil || metaInt <= 0 {
+		return resp.Body, nil
+	}
+
+	return &icyReader{
+		r:         bufio.NewReader(resp.Body),
+		c:         resp.Body,
+		metaInt:   metaInt,
+		remaining: metaInt,
+		onTitle:   t.setStreamTitle,
+	}, nil
+}
+
+func (t *httpTrack) setStreamTitle(title string) {
+	t.Lock()
+	t.streamTitle = title
+	t.Unlock()
+}
+
+// icyReader strips the ICY metadata blocks which are interleaved into an Icecast stream every
+// metaInt bytes, passing the stream title from each block to onTitle.
+type icyReader struct {
+	r         io.Reader
+	c         io.Closer
+	metaInt   int
+	remaining int
+	onTitle   func(string)
+}
+
+func (i *icyReader) Read(p []byte) (int, error) {
+	if i.remaining == 0 {
+		err := i.readMetadata()
+		if err != nil {
+			return 0, err
+		}
+		i.remaining = i.metaInt
+	}
+
+	if len(p) > i.remaining {
+		p = p[:i.remaining]
+	}
+	n, err := i.r.Read(p)
+	i.remaining -= n
+	return n, err
+}
+
+func (i *icyReader) Close() error {
+	return i.c.Close()
+}
+
+func (i *icyReader) readMetadata() error {
+	var length [1]byte
+	_, err := io.ReadFull(i.r, length[:])
+	if err != nil {
+		return err
+	}
+	if length[0] == 0 {
+		return nil
+	}
+
+	meta := make([]byte, int(length[0])*16)
+	_, err = io.ReadFull(i.r, meta)
+	if err != nil {
+		return err
+	}
+
+	if title, ok := parseStreamTitle(string(meta)); ok {
+		i.onTitle(title)
+	}
+	return nil
+}
+
+// parseStreamTitle extracts the StreamTitle field from an ICY metadata block of the form
+// StreamTitle='Artist - Title';StreamUrl='...';
+func parseStreamTitle(meta string) (string, bool) {
+	const key = "StreamTitle='"
+	start := strings.Index(meta, key)
+	if start < 0 {
+		return "", false
+	}
+	meta = meta[start+len(key):]
+	end := strings.Index(meta, "';")
+	if end < 0 {
+		return "", false
+	}
+	return meta[:end], true
+}
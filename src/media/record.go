@@ -0,0 +1,525 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/jonas747/ogg"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// MaxRecordingDuration is the longest a recording can run before it is stopped automatically.
+	MaxRecordingDuration = 2 * time.Hour
+	// MaxUploadedRecordingDuration is the longest a recording can run if no recording directory is
+	// set, as longer recordings are too large to upload.
+	MaxUploadedRecordingDuration = 15 * time.Minute
+)
+
+const (
+	// maxUploadSize is the largest file a bot can upload to a channel, in bytes.
+	maxUploadSize = 8 << 20
+	// maxUploadFiles is the most files which can be attached to one message.
+	maxUploadFiles = 10
+)
+
+const (
+	// frameDuration is the length of the Opus frames sent by Discord clients, and frameSamples is
+	// the number of samples in each at 48kHz.
+	frameDuration = 20 * time.Millisecond
+	frameSamples  = 960
+	// jitterFrames is how far a speaker's packets may fall behind the recording before the gap is
+	// filled with silence.
+	jitterFrames = 5
+)
+
+// opusSilence is an Opus frame of 20ms of silence.
+var opusSilence = []byte{0xf8, 0xff, 0xfe}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+var (
+	recordingDir string
+	recordingMu  sync.RWMutex
+)
+
+// SetRecordingDir sets the directory in which recordings are saved. If it is not set, recordings
+// are uploaded to the channel they were started from instead.
+func SetRecordingDir(dir string) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	recordingMu.Lock()
+	recordingDir = dir
+	recordingMu.Unlock()
+	return nil
+}
+
+func currentRecordingDir() string {
+	recordingMu.RLock()
+	defer recordingMu.RUnlock()
+	return recordingDir
+}
+
+// splitRecordData splits the CommandData of a RECORD request into the verb and the text channel
+// the recording is reported to.
+func splitRecordData(data string) (string, string) {
+	verb := strings.Fields(data + " ")[0]
+	return verb, strings.TrimSpace(strings.TrimPrefix(data, verb))
+}
+
+// guildRecorder records every speaker in a voice channel to their own Ogg/Opus file until it is
+// told to stop, everyone leaves, or MaxRecordingDuration passes, or MaxUploadedRecordingDuration
+// if it is to be uploaded. The files are then saved or uploaded, and reported to the text channel
+// the recording was started from.
+func guildRecorder(
+	discordSession *dgo.Session,
+	guildID, channelID string,
+	start playerCommand,
+	controlChannel <-chan playerCommand,
+	recordStop, recordEnd chan<- string,
+) {
+	_, textChannelID := splitRecordData(start.commandData)
+
+	finish := func() {
+		recordStop <- guildID
+		recordEnd <- guildID
+	}
+
+	rec, err := newRecording(discordSession, guildID, textChannelID)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't create recording")
+		go trySend(start.returnChannel, errReply(StatusFailed, err, "Couldn't start recording."),
+			stdTimeout)
+		finish()
+		return
+	}
+
+	// The connection is not deafened, as audio is only received from undeafened connections.
+	vc, err := discordSession.ChannelVoiceJoin(guildID, channelID, false, false)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't initialise voice connection")
+		go trySend(start.returnChannel, errReply(StatusFailed, err, "Couldn't join your channel."),
+			stdTimeout)
+		if err := os.RemoveAll(rec.dir); err != nil {
+			log.Error().Err(err).Msg("")
+		}
+		finish()
+		return
+	}
+	vc.AddHandler(rec.speakingUpdate)
+
+	// Discord does not send audio to a connection until it has sent some itself.
+	for i := 0; i < 5; i++ {
+		vc.OpusSend <- opusSilence
+	}
+
+	limit := MaxRecordingDuration
+	if rec.upload {
+		limit = MaxUploadedRecordingDuration
+	}
+
+	log.Info().Str("guildID", guildID).Str("channelID", channelID).Msg("Recording started")
+	go trySend(start.returnChannel, okReply("Recording started."), stdTimeout)
+	// The notice is sent separately from the reply, so that it is seen even if joining the channel
+	// took too long for the reply to be delivered.
+	rec.notify(fmt.Sprintf("Recording <#%s> for up to %v. Everyone who speaks in the channel will "+
+		"be recorded. If you do not consent to being recorded, leave the channel or ask for the "+
+		"recording to be stopped.", channelID, limit))
+
+	var reason string
+	timeout := time.NewTimer(limit)
+	defer timeout.Stop()
+recordLoop:
+	for {
+		select {
+		case p := <-vc.OpusRecv:
+			err := rec.write(p)
+			if err != nil {
+				log.Error().Err(err).Msg("")
+				reason = "Recording failed."
+				break recordLoop
+			}
+		case control := <-controlChannel:
+			switch control.commandType {
+			case record:
+				go trySend(control.returnChannel, okReply("Recording stopped."), stdTimeout)
+				break recordLoop
+			case listenersChanged:
+				if len(channelListeners(discordSession, guildID, channelID)) == 0 {
+					reason = "Recording stopped because everyone left."
+					break recordLoop
+				}
+			default:
+				go trySend(control.returnChannel, errReply(StatusRejected, ErrRecording,
+					"Cannot play media while recording."), stdTimeout)
+			}
+		case <-timeout.C:
+			reason = fmt.Sprintf("Recording stopped after %v.", limit)
+			break recordLoop
+		}
+	}
+
+	recordStop <- guildID
+	err = vc.Disconnect()
+	if err != nil {
+		log.Error().Err(err).Msg("")
+	}
+	log.Info().Str("guildID", guildID).Msg("Recording finished")
+
+	rec.deliver(reason)
+	recordEnd <- guildID
+}
+
+// recording is the set of tracks being recorded in a voice channel, one per speaker.
+type recording struct {
+	session       *dgo.Session
+	guildID       string
+	textChannelID string
+	dir           string
+	upload        bool
+	started       time.Time
+	tracks        map[uint32]*speakerTrack
+
+	// users maps the SSRC of each stream of audio to the user speaking, and announcedUsers holds
+	// the users who have been told they are being recorded. Both are guarded by the mutex, as
+	// they are updated by the voice connection.
+	sync.Mutex
+	users          map[uint32]string
+	announcedUsers map[string]bool
+}
+
+// newRecording creates the directory a new recording is written to. Recordings which are to be
+// uploaded are written to a temporary directory.
+func newRecording(s *dgo.Session, guildID, textChannelID string) (*recording, error) {
+	started := time.Now()
+	rec := &recording{
+		session:        s,
+		guildID:        guildID,
+		textChannelID:  textChannelID,
+		started:        started,
+		tracks:         make(map[uint32]*speakerTrack),
+		users:          make(map[uint32]string),
+		announcedUsers: make(map[string]bool),
+	}
+
+	var err error
+	if dir := currentRecordingDir(); dir != "" {
+		rec.dir = filepath.Join(dir, guildID, started.Format("20060102-150405"))
+		err = os.MkdirAll(rec.dir, os.ModePerm)
+	} else {
+		rec.upload = true
+		rec.dir, err = os.MkdirTemp("", "strife-recording-")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// speakingUpdate learns which user each incoming stream of audio belongs to, and tells each user
+// the first time they are heard that they are being recorded.
+func (r *recording) speakingUpdate(_ *dgo.VoiceConnection, vs *dgo.VoiceSpeakingUpdate) {
+	r.Lock()
+	r.users[uint32(vs.SSRC)] = vs.UserID
+	announced := r.announcedUsers[vs.UserID]
+	r.announcedUsers[vs.UserID] = true
+	r.Unlock()
+
+	if !announced {
+		go r.notify(fmt.Sprintf("<@%s> is being recorded.", vs.UserID))
+	}
+}
+
+// write adds an incoming packet to the track of its speaker.
+func (r *recording) write(p *dgo.Packet) error {
+	if len(p.Opus) == 0 {
+		return nil
+	}
+	t, ok := r.tracks[p.SSRC]
+	if !ok {
+		var err error
+		t, err = newSpeakerTrack(filepath.Join(r.dir, fmt.Sprintf("%d.ogg", p.SSRC)), p.SSRC)
+		if err != nil {
+			return err
+		}
+		r.tracks[p.SSRC] = t
+	}
+	return t.write(time.Since(r.started), p.Opus)
+}
+
+// close finishes every track, naming each file after its speaker's ID, and returns their paths.
+func (r *recording) close() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	var files []string
+	used := make(map[string]bool)
+	for ssrc, t := range r.tracks {
+		err := t.close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+
+		name := fmt.Sprintf("unknown-%d", ssrc)
+		if userID, ok := r.users[ssrc]; ok {
+			name = unsafeFileChars.ReplaceAllString(userID, "_")
+		}
+		file := name + ".ogg"
+		for i := 2; used[file]; i++ {
+			file = fmt.Sprintf("%s-%d.ogg", name, i)
+		}
+		used[file] = true
+
+		path := filepath.Join(r.dir, file)
+		err = os.Rename(t.path, path)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			path = t.path
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+// deliver closes the recording and reports it to the text channel it was started from, uploading
+// the files if no recording directory is set. reason explains why the recording stopped, if it
+// was not stopped by a user.
+func (r *recording) deliver(reason string) {
+	files := r.close()
+
+	var report []string
+	if reason != "" {
+		report = append(report, reason)
+	}
+	switch {
+	case len(files) == 0:
+		report = append(report, "Nobody spoke, so nothing was recorded.")
+		if err := os.RemoveAll(r.dir); err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	case !r.upload:
+		report = append(report, fmt.Sprintf("Recording saved as %s.", filepath.Base(r.dir)))
+	default:
+		report = append(report, r.uploadFiles(files)...)
+	}
+	r.notify(strings.Join(report, "\n"))
+}
+
+// uploadFiles uploads files to the text channel, in as few messages as the upload limits allow,
+// and then removes the recording. It returns a message for each file which could not be uploaded.
+func (r *recording) uploadFiles(files []string) []string {
+	var report []string
+	var batch []string
+	var batchSize int64
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		msg := &dgo.MessageSend{}
+		for _, path := range batch {
+			f, err := os.Open(path)
+			if err != nil {
+				log.Error().Err(err).Msg("")
+				report = append(report, fmt.Sprintf("Couldn't upload %s.", filepath.Base(path)))
+				continue
+			}
+			defer f.Close()
+			msg.Files = append(msg.Files, &dgo.File{
+				Name:        filepath.Base(path),
+				ContentType: "audio/ogg",
+				Reader:      f,
+			})
+		}
+		if len(msg.Files) > 0 {
+			_, err := r.session.ChannelMessageSendComplex(r.textChannelID, msg)
+			if err != nil {
+				log.Error().Err(err).Msg("Couldn't upload recording")
+				for _, f := range msg.Files {
+					report = append(report, fmt.Sprintf("Couldn't upload %s.", f.Name))
+				}
+			}
+		}
+		batch, batchSize = nil, 0
+	}
+
+	// The upload limit applies to each message as a whole, so files are sent together only while
+	// their total size is within it.
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		if info.Size() > maxUploadSize {
+			report = append(report, fmt.Sprintf("%s is too large to upload.",
+				filepath.Base(path)))
+			continue
+		}
+		if len(batch) == maxUploadFiles || batchSize+info.Size() > maxUploadSize {
+			send()
+		}
+		batch = append(batch, path)
+		batchSize += info.Size()
+	}
+	send()
+
+	// Files which could not be uploaded are not kept, as they could not be retrieved from the
+	// temporary directory.
+	if err := os.RemoveAll(r.dir); err != nil {
+		log.Error().Err(err).Msg("")
+	}
+	return report
+}
+
+// notify sends a message to the text channel the recording was started from.
+func (r *recording) notify(message string) {
+	_, err := r.session.ChannelMessageSend(r.textChannelID, "**"+message+"**")
+	if err != nil {
+		log.Error().Err(err).Msg("")
+	}
+}
+
+// speakerTrack is the audio received from one speaker, written to an Ogg/Opus file. Gaps while
+// the speaker is silent are filled with silence, so that every track in a recording stays in time.
+type speakerTrack struct {
+	path    string
+	file    *os.File
+	pages   *pageSequencer
+	ogg     *ogg.Encoder
+	granule int64
+}
+
+func newSpeakerTrack(path string, serial uint32) (*speakerTrack, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &speakerTrack{path: path, file: f, pages: &pageSequencer{w: f}}
+	t.ogg = ogg.NewEncoder(serial, t.pages)
+
+	err = t.ogg.EncodeBOS(0, opusHead())
+	if err == nil {
+		err = t.ogg.Encode(0, opusTags())
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// write adds packet to the track at elapsed time into the recording.
+func (t *speakerTrack) write(elapsed time.Duration, packet []byte) error {
+	behind := int64(elapsed/frameDuration) - t.granule/frameSamples
+	for ; behind > jitterFrames; behind-- {
+		t.granule += frameSamples
+		if err := t.ogg.Encode(t.granule, opusSilence); err != nil {
+			return err
+		}
+	}
+	t.granule += frameSamples
+	return t.ogg.Encode(t.granule, packet)
+}
+
+func (t *speakerTrack) close() error {
+	err := t.pages.finish()
+	if closeErr := t.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// opusHead returns the identification header of a stereo Ogg/Opus stream.
+func opusHead() []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // Version
+	head[9] = 2 // Channels
+	binary.LittleEndian.PutUint32(head[12:], 48000)
+	return head
+}
+
+// opusTags returns the comment header of an Ogg/Opus stream, which has no comments.
+func opusTags() []byte {
+	const vendor = "strife"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	return tags
+}
+
+// pageSequencer numbers the pages written by an ogg.Encoder, which leaves every page numbered
+// zero, and marks the last page as the end of the stream. Each write must be a whole page. The
+// latest page is held back until the next is written, so that it can be marked if it is the last.
+type pageSequencer struct {
+	w       io.Writer
+	page    uint32
+	pending []byte
+	held    bool
+}
+
+func (p *pageSequencer) Write(b []byte) (int, error) {
+	if err := p.flush(); err != nil {
+		return 0, err
+	}
+	p.pending = append(p.pending[:0], b...)
+	p.held = true
+	binary.LittleEndian.PutUint32(p.pending[18:], p.page)
+	p.page++
+	return len(b), nil
+}
+
+// finish writes the last page, marked as the end of the stream.
+func (p *pageSequencer) finish() error {
+	if !p.held {
+		return nil
+	}
+	p.pending[5] |= ogg.EOS
+	return p.flush()
+}
+
+func (p *pageSequencer) flush() error {
+	if !p.held {
+		return nil
+	}
+	p.held = false
+	binary.LittleEndian.PutUint32(p.pending[22:], 0)
+	binary.LittleEndian.PutUint32(p.pending[22:], oggChecksum(p.pending))
+	_, err := p.w.Write(p.pending)
+	return err
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggChecksum returns the checksum of an Ogg page, which must have its checksum field zeroed.
+func oggChecksum(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
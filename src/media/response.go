@@ -0,0 +1,88 @@
+package media
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dpatterbee/strife/src/store"
+)
+
+//go:generate stringer -type=Status
+
+// Status is the outcome of a Request, which is described in more detail by the Err of its
+// Response.
+type Status int
+
+const (
+	// StatusOK means the request was carried out.
+	StatusOK Status = iota
+	// StatusInvalid means the CommandData of the request could not be understood.
+	StatusInvalid
+	// StatusRejected means the request is not allowed, or cannot be carried out in the current
+	// state of the player.
+	StatusRejected
+	// StatusNotFound means the song, playlist, sound or queue position requested does not exist.
+	StatusNotFound
+	// StatusFailed means an error occurred while carrying out the request.
+	StatusFailed
+	// StatusBusy means the request could not be accepted at the moment.
+	StatusBusy
+	// StatusTimeout means the request was delivered, but no reply arrived before the context
+	// passed to Send was done. The request may still be carried out.
+	StatusTimeout
+)
+
+var (
+	// ErrNotPlaying is the error used when a request needs a player but none is active
+	ErrNotPlaying = errors.New("no media playing")
+	// ErrNotReady is the error used when a request is made before the current song has started
+	ErrNotReady = errors.New("player not ready")
+	// ErrNotInChannel is the error used when a request is made from outside the player's channel
+	ErrNotInChannel = errors.New("not in player's voice channel")
+	// ErrNotPermitted is the error used when a user is not allowed to make a request
+	ErrNotPermitted = errors.New("not permitted")
+	// ErrInvalidRequest is the error used when the CommandData of a request is invalid
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrNotFound is the error used when the target of a request does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrQueueFull is the error used when there is no room left in a queue
+	ErrQueueFull = errors.New("queue full")
+	// ErrPolicy is the error used when a request is refused by the guild's queue policy
+	ErrPolicy = errors.New("refused by queue policy")
+	// ErrRecording is the error used when media cannot be played because of a recording
+	ErrRecording = errors.New("recording in progress")
+	// ErrShuttingDown is the error used when the controller is shutting down
+	ErrShuttingDown = errors.New("shutting down")
+)
+
+// Response is the result of a Request.
+type Response struct {
+	Status Status
+	// Err is the kind of error which caused an unsuccessful request, and can be compared to the
+	// errors exported by this package with errors.Is. It is nil if the request succeeded.
+	Err error
+	// Message describes the outcome to the user who made the request.
+	Message string
+	// Track is the song the request concerns, if any, such as a song added to the queue or the
+	// song playing. Position is how far into it playback has reached, if it is playing.
+	Track    *store.TrackRef
+	Position time.Duration
+	// Queue holds the songs waiting to be played, for INSPECT.
+	Queue []store.TrackRef
+}
+
+// okReply returns a Response for a successful request.
+func okReply(message string) Response {
+	return Response{Status: StatusOK, Message: message}
+}
+
+// trackReply returns a Response for a successful request concerning track.
+func trackReply(message string, track queuedTrack) Response {
+	ref := trackRef(track)
+	return Response{Status: StatusOK, Message: message, Track: &ref}
+}
+
+// errReply returns a Response for an unsuccessful request.
+func errReply(status Status, err error, message string) Response {
+	return Response{Status: status, Err: err, Message: message}
+}
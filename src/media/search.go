@@ -0,0 +1,46 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SearchResult is a single result returned by a SearchProvider.
+type SearchResult struct {
+	Title    string
+	Duration time.Duration
+	// URL is a query which can be resolved by a registered Source to play the result.
+	URL string
+}
+
+// SearchProvider finds songs matching free text search terms.
+type SearchProvider interface {
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// ErrNoResults is the error used when a search returns no results
+var ErrNoResults = errors.New("no search results")
+
+var (
+	searchMu       sync.RWMutex
+	searchProvider SearchProvider = youtubeSearch{}
+)
+
+// SetSearchProvider replaces the SearchProvider used to resolve requests which are not accepted
+// by any Source.
+func SetSearchProvider(p SearchProvider) {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+	searchProvider = p
+}
+
+// Search returns up to limit results for query from the current SearchProvider.
+func Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	searchMu.RLock()
+	p := searchProvider
+	searchMu.RUnlock()
+
+	return p.Search(ctx, query, limit)
+}
@@ -1,23 +1,53 @@
 package media
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	dgo "github.com/bwmarrin/discordgo"
-	yt "github.com/kkdai/youtube/v2"
+	"github.com/dpatterbee/strife/src/store"
 	"github.com/rs/zerolog/log"
 )
 
+// pauseTimeout is how long a song may be paused before the player disconnects.
+const pauseTimeout = 30 * time.Minute
+
+// DefaultIdleSettings are the idle settings of guilds which have not set their own.
+var DefaultIdleSettings = store.IdleSettings{
+	QueueTimeout: 5 * time.Second,
+	AloneTimeout: time.Minute,
+}
+
+// loadIdleSettings returns the idle settings of the guild, or the defaults if none have been set.
+func loadIdleSettings(st store.Store, guildID string) store.IdleSettings {
+	settings, err := st.GetIdleSettings(guildID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Msg("")
+		}
+		return DefaultIdleSettings
+	}
+	return settings
+}
+
 // guildSoundPlayer runs while a server has a queue of songs to be played.
-// It loops over the queue of songs and plays them in order, exiting once it has drained the list
+// It loops over the queue of songs and plays them in order, exiting once it has drained the list.
+// If the player is started by a soundboard or say command, clip is that command, and the player
+// plays the clip before waiting for songs.
 func guildSoundPlayer(
 	discordSession *dgo.Session,
+	st store.Store,
+	events *eventBus,
 	guildID, channelID string,
 	controlChannel <-chan playerCommand,
 	songChannel <-chan songReq,
 	mediaReturnRequestChan, mediaReturnFinishChan chan<- string,
 	previousInstanceWaitChan <-chan bool,
+	clip *playerCommand,
 ) {
 	log.Info().Msg("Sound handler not active, activating")
 
@@ -25,13 +55,66 @@ func guildSoundPlayer(
 		<-previousInstanceWaitChan
 	}
 
-	queue := newSongQueue(songChannel)
+	// The clip is loaded before joining the channel, so that the player does not join only to
+	// leave again if it cannot be played.
+	var clipTrack Track
+	if clip != nil {
+		var err error
+		clipTrack, err = loadClip(st, guildID, *clip)
+		if err != nil {
+			go trySend(clip.returnChannel, clipError(err), stdTimeout)
+			log.Info().Msg("Initial clip request failed, shutting down.")
+			mediaReturnRequestChan <- guildID
+			mediaReturnFinishChan <- guildID
+			return
+		}
+	}
 
-	if !<-queue.firstSongWait {
-		log.Info().Msg("Initial song request too long, shutting down.")
-		mediaReturnRequestChan <- guildID
-		mediaReturnFinishChan <- guildID
-		return
+	queue := newSongQueue(guildID, songChannel, st, events, clip == nil)
+
+	// Wait for the first request to be resolved, which may take a while for a playlist. A
+	// disconnect received in the meantime cancels the resolution.
+firstSongLoop:
+	for clip == nil {
+		select {
+		case ok := <-queue.firstSongWait:
+			if !ok {
+				log.Info().Msg("Initial song request failed, shutting down.")
+				mediaReturnRequestChan <- guildID
+				mediaReturnFinishChan <- guildID
+				return
+			}
+			break firstSongLoop
+		case control := <-controlChannel:
+			// The voice channel has not been joined yet, so the player can be moved by just
+			// changing which channel it will join.
+			if control.commandType == summon {
+				if control.user.Privileged || control.channelID == channelID {
+					channelID = control.channelID
+					go trySend(control.returnChannel, okReply("Moved to your channel."), stdTimeout)
+				} else {
+					go trySend(control.returnChannel, errReply(StatusRejected, ErrNotPermitted,
+						"Only DJs can move the player."), stdTimeout)
+				}
+				continue
+			}
+			if control.commandType != disconnect && control.commandType != playerShutdown {
+				go trySend(control.returnChannel, errReply(StatusRejected, ErrNotPlaying,
+					"No media playing."), stdTimeout)
+				continue
+			}
+			queue.cancel()
+			// The queue is only still running if the request completed before it could be
+			// cancelled.
+			if <-queue.firstSongWait {
+				remainingQ := make(chan []queuedTrack)
+				queue.shutdown <- remainingQ
+				saveQueue(st, guildID, channelID, disconnectReason(control), nil, <-remainingQ)
+			}
+			go trySend(control.returnChannel, okReply("Goodbye."), stdTimeout)
+			mediaReturnFinishChan <- guildID
+			return
+		}
 	}
 
 	// Set up voiceconnection
@@ -40,39 +123,170 @@ func guildSoundPlayer(
 	if err != nil {
 		mediaReturnRequestChan <- guildID
 		log.Error().Err(err).Msg("Couldn't initialise voice connection")
+		remainingQ := make(chan []queuedTrack)
+		queue.cancel()
+		queue.shutdown <- remainingQ
+		saveQueue(st, guildID, channelID, store.SaveIdle, nil, <-remainingQ)
+		mediaReturnFinishChan <- guildID
 		return
 	}
 
-	disconnectTimer := time.NewTimer(5 * time.Second)
+	// interrupted is set to the song which was playing if the player shuts down part way
+	// through it, so that it can be saved, and reason is set to why the player shut down.
+	var interrupted *queuedTrack
+	reason := store.SaveIdle
+
+	// disconnectTimer runs while the player is idle, either because the queue is empty, because
+	// the current song is paused, or because nobody else is in the voice channel.
+	idle := loadIdleSettings(st, guildID)
+	disconnectTimer := time.NewTimer(idle.QueueTimeout)
+
+	// alone is true while nobody else is in the voice channel, and autoPaused is true if the
+	// current song was paused because of it.
+	alone, autoPaused := false, false
+
+	// effects are applied to every song played until they are changed.
+	effects := noEffects
+
+	// prefetched is the next song, prepared while the current song plays.
+	var prefetched *prefetchedSong
+
+	// checkAlone rechecks whether anybody else is in the voice channel while the player is idle,
+	// returning whether that changed.
+	checkAlone := func() bool {
+		nowAlone := len(channelListeners(discordSession, guildID, channelID)) == 0
+		if nowAlone == alone {
+			return false
+		}
+		alone = nowAlone
+		return true
+	}
 
 	log.Info().Msg("Media session ready")
+	// leaving is set if the player is told to leave while the first clip plays.
+	leaving := false
+	if clip != nil {
+		go trySend(clip.returnChannel, clipStarted(*clip, clipTrack), stdTimeout)
+		stop, _, err := playSound(vc, clipTrack, loadAudioSettings(st, guildID), controlChannel)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+		if stop != nil {
+			reason = disconnectReason(*stop)
+			go trySend(stop.returnChannel, okReply("Goodbye."), stdTimeout)
+			leaving = true
+		}
+		checkAlone()
+	}
+	// armIdle is set when the player becomes idle, or when whether it is alone changes while idle,
+	// so that the disconnect timer is restarted. Other events leave the timer running.
+	armIdle := true
 mainLoop:
-	for {
-		if !disconnectTimer.Stop() {
-			<-disconnectTimer.C
+	for !leaving {
+		if settings := loadIdleSettings(st, guildID); settings != idle {
+			idle = settings
+			armIdle = true
+		}
+		if armIdle {
+			armIdle = false
+			if alone && idle.AloneTimeout < idle.QueueTimeout {
+				resetTimer(disconnectTimer, idle.AloneTimeout)
+			} else {
+				resetTimer(disconnectTimer, idle.QueueTimeout)
+			}
 		}
-		disconnectTimer.Reset(5 * time.Second)
 		select {
 		case control := <-controlChannel:
 			switch control.commandType {
-			case disconnect:
-				go trySend(control.returnChannel, "Goodbye.", stdTimeout)
+			case disconnect, playerShutdown:
+				reason = disconnectReason(control)
+				go trySend(control.returnChannel, okReply("Goodbye."), stdTimeout)
 				break mainLoop
+			case listenersChanged, summon:
+				if control.commandType == summon {
+					var reply Response
+					channelID, reply = summonPlayer(discordSession, vc, guildID, channelID, control)
+					go trySend(control.returnChannel, reply, stdTimeout)
+				}
+				if checkAlone() {
+					armIdle = true
+				}
+			case soundboard, say:
+				clip, err := loadClip(st, guildID, control)
+				if err != nil {
+					go trySend(control.returnChannel, clipError(err), stdTimeout)
+					continue
+				}
+				go trySend(control.returnChannel, clipStarted(control, clip), stdTimeout)
+				stop, changed, err := playSound(vc, clip, loadAudioSettings(st, guildID),
+					controlChannel)
+				if err != nil {
+					log.Error().Err(err).Msg("")
+				}
+				if stop != nil {
+					reason = disconnectReason(*stop)
+					go trySend(stop.returnChannel, okReply("Goodbye."), stdTimeout)
+					break mainLoop
+				}
+				if changed {
+					checkAlone()
+				}
+				// The player was not idle while the clip played.
+				armIdle = true
+			case remove, move, shuffle, clear, loop:
+				go sendQueueEdit(queue, control)
+			case volume, normalize:
+				_, _, reply := updateAudioSettings(st, guildID, control.commandType,
+					control.commandData)
+				go trySend(control.returnChannel, reply, stdTimeout)
+			case filter:
+				var reply Response
+				effects, _, reply = effects.apply(control.commandData)
+				go trySend(control.returnChannel, reply, stdTimeout)
+			case savePlaylist:
+				qch := make(chan queueSnapshot)
+				queue.inspectSongQueue <- qch
+				q := <-qch
+				go trySend(control.returnChannel,
+					storePlaylist(st, control.commandData, nil, q.tracks), stdTimeout)
 			default:
-				go trySend(control.returnChannel, "No media playing.", stdTimeout)
+				go trySend(control.returnChannel, errReply(StatusRejected, ErrNotPlaying,
+					"No media playing."), stdTimeout)
 			}
 		case song := <-queue.nextSong:
 			log.Info().
-				Str("URL", song.ID).
-				Str("Title", song.Title).
+				Str("ID", song.ID()).
+				Str("Source", song.Source()).
+				Str("Title", song.Title()).
 				Str("guildID", guildID).
 				Msg("Playing Song")
 			//encode, download, err := newSongSession(song)
 			//streamingSession := newStreamingSession(encode, vc)
-			mediaSession, err := newMediaSession(song, vc)
-			if err != nil {
-				log.Error().Err(err).Msg("")
-				return
+			audio := loadAudioSettings(st, guildID)
+
+			// The song is announced before it is opened, so that it does not fall behind while
+			// the announcement plays.
+			var recheckListeners bool
+			if !alone {
+				var stop *playerCommand
+				stop, recheckListeners = announceSong(st, guildID, vc, song, audio, controlChannel)
+				if stop != nil {
+					reason = disconnectReason(*stop)
+					interrupted = &song
+					go trySend(stop.returnChannel, okReply("Goodbye."), stdTimeout)
+					break mainLoop
+				}
+			}
+
+			mediaSession := prefetched.take(song, audio, effects)
+			prefetched = nil
+			if mediaSession == nil {
+				mediaSession, err = newMediaSession(song, vc, song.start, audio, effects)
+				if err != nil {
+					log.Error().Err(err).Msg("")
+					armIdle = true
+					continue
+				}
 			}
 
 			err = vc.Speaking(true)
@@ -86,6 +300,102 @@ mainLoop:
 				Msg("Starting Audio Stream")
 
 			mediaSession.stream.Start()
+			autoPaused = false
+			if alone {
+				autoPaused = mediaSession.pause()
+				resetTimer(disconnectTimer, idle.AloneTimeout)
+			} else {
+				stopTimer(disconnectTimer)
+			}
+			votes := newSkipVote()
+
+			// The song is only recorded as played once it has been opened, as it may no longer
+			// be available. opened is nil once the outcome is known.
+			var playID int64
+			started := false
+			opened := mediaSession.download.opened
+
+			// songOpened handles the outcome of opening the song. A song which could not be
+			// opened is abandoned without being recorded as played, and false is returned.
+			songOpened := func(err error) bool {
+				opened = nil
+				if err != nil {
+					log.Error().Err(err).Str("guildID", guildID).Msg("Couldn't open song")
+					mediaSession.stop()
+					if err := vc.Speaking(false); err != nil {
+						log.Error().Err(err).Msg("")
+					}
+					e := trackEvent(EventTrackFailed, guildID, song)
+					e.Err = err
+					events.publish(e)
+					return false
+				}
+				started = true
+				playID = recordPlay(st, guildID, song)
+				events.publish(trackEvent(EventTrackStarted, guildID, song))
+				return true
+			}
+
+			// endSong records that the song has stopped playing.
+			endSong := func(skipped bool) {
+				if !started {
+					return
+				}
+				finishPlay(st, playID, skipped)
+				e := trackEvent(EventTrackEnded, guildID, song)
+				e.Skipped = skipped
+				events.publish(e)
+			}
+
+			// restartSong replaces the stopped media session with one playing the song from pos.
+			// If the song cannot be restarted, it is finished as if it had ended and the error is
+			// returned, after which the player must move on to the next song.
+			restartSong := func(pos time.Duration, paused bool) error {
+				m, err := startMedia(song, vc, pos, paused, audio, effects)
+				if err != nil {
+					log.Error().Err(err).Str("guildID", guildID).Msg("Couldn't restart song")
+					if err := vc.Speaking(false); err != nil {
+						log.Error().Err(err).Msg("")
+					}
+					endSong(false)
+					return err
+				}
+				mediaSession = m
+				// The song is opened again, so the outcome is taken from the new session.
+				if opened != nil {
+					opened = m.download.opened
+				}
+				return nil
+			}
+
+			// checkListeners pauses the song when everyone else leaves the voice channel, and
+			// resumes it when someone returns.
+			checkListeners := func() {
+				nowAlone := len(channelListeners(discordSession, guildID, channelID)) == 0
+				if nowAlone == alone {
+					return
+				}
+				alone = nowAlone
+
+				if alone {
+					log.Info().Str("guildID", guildID).Msg("Alone in voice channel, pausing.")
+					autoPaused = mediaSession.pause()
+					resetTimer(disconnectTimer, idle.AloneTimeout)
+					return
+				}
+				if autoPaused {
+					autoPaused = false
+					mediaSession.resume()
+				}
+				if mediaSession.stream.Paused() {
+					resetTimer(disconnectTimer, pauseTimeout)
+				} else {
+					stopTimer(disconnectTimer)
+				}
+			}
+			if recheckListeners {
+				checkListeners()
+			}
 
 			// controlLoop should only be entered once it is possible to control the media ie. once
 			// the ffmpeg session is up and running
@@ -93,7 +403,17 @@ mainLoop:
 			for {
 				select {
 
+				case err := <-opened:
+					if !songOpened(err) {
+						break controlLoop
+					}
+
 				case err := <-mediaSession.stream.done:
+					// A song which could not be opened ends straight away. The outcome of
+					// opening it is always sent before the stream can end.
+					if opened != nil && !songOpened(<-opened) {
+						break controlLoop
+					}
 					if err := vc.Speaking(false); err != nil {
 						log.Error().Err(err).Msg("")
 					}
@@ -103,61 +423,266 @@ mainLoop:
 					} else {
 						log.Error().Err(err).Msg("Song Stopped.")
 					}
+					endSong(false)
 					break controlLoop
 
+				case next := <-queue.upcoming:
+					// The song being played may still be announced as upcoming if the
+					// announcement was made before the player received it.
+					if next.serial != song.serial {
+						prefetched = prefetch(prefetched, next, vc, audio, effects)
+					}
+
+				case <-disconnectTimer.C:
+					if alone {
+						log.Info().Str("guildID", guildID).Msg("Alone for too long, disconnecting.")
+					} else {
+						log.Info().Str("guildID", guildID).Msg("Paused for too long, disconnecting.")
+					}
+					interrupted = interruptedTrack(song, mediaSession)
+					mediaSession.stop()
+					endSong(false)
+					mediaReturnRequestChan <- guildID
+					break mainLoop
+
 				case control := <-controlChannel:
 
 					switch control.commandType {
 
+					case listenersChanged:
+						checkListeners()
+
+					case soundboard, say:
+						clip, err := loadClip(st, guildID, control)
+						if err != nil {
+							go trySend(control.returnChannel, clipError(err), stdTimeout)
+							continue
+						}
+
+						// The song is stopped while the clip plays, then resumed from where it
+						// was interrupted. A live stream is resumed from now.
+						var pos time.Duration
+						if song.Duration() != 0 {
+							pos = mediaSession.stream.PlaybackPos()
+						}
+						paused := mediaSession.stream.Paused()
+						mediaSession.stop()
+
+						go trySend(control.returnChannel, clipStarted(control, clip), stdTimeout)
+						stop, changed, err := playSound(vc, clip, audio, controlChannel)
+						if err != nil {
+							log.Error().Err(err).Msg("")
+						}
+						if stop != nil {
+							reason = disconnectReason(*stop)
+							interrupted = &song
+							interrupted.start = pos
+							endSong(false)
+							go trySend(stop.returnChannel, okReply("Goodbye."), stdTimeout)
+							break mainLoop
+						}
+
+						if err := restartSong(pos, paused); err != nil {
+							break controlLoop
+						}
+						if changed {
+							checkListeners()
+						}
+
+					case summon:
+						var reply Response
+						channelID, reply = summonPlayer(discordSession, vc, guildID, channelID,
+							control)
+						go trySend(control.returnChannel, reply, stdTimeout)
+						checkListeners()
+
 					case pause:
 						ok := mediaSession.pause()
 						if ok {
-							go trySend(control.returnChannel, "Song paused.", stdTimeout)
+							resetTimer(disconnectTimer, pauseTimeout)
+							go trySend(control.returnChannel, trackReply("Song paused.", song), stdTimeout)
 						} else {
-							go trySend(control.returnChannel, "Song already paused.", stdTimeout)
+							go trySend(control.returnChannel, trackReply("Song already paused.", song),
+								stdTimeout)
 						}
 
 					case resume:
 						ok := mediaSession.resume()
 						if ok {
-							go trySend(control.returnChannel, "Song resumed.", stdTimeout)
+							autoPaused = false
+							// If nobody is listening, the player still leaves once the grace
+							// period is over.
+							if !alone {
+								stopTimer(disconnectTimer)
+							}
+							go trySend(control.returnChannel, trackReply("Song resumed.", song), stdTimeout)
 						} else {
-							go trySend(control.returnChannel, "Song already playing", stdTimeout)
+							go trySend(control.returnChannel, trackReply("Song already playing", song),
+								stdTimeout)
 						}
 
 					case skip:
 						if !mediaSession.encode.Running() {
-							go trySend(control.returnChannel, "Not yet.", stdTimeout)
+							go trySend(control.returnChannel, errReply(StatusBusy, ErrNotReady, "Not yet."),
+								stdTimeout)
 							continue
 
 						}
+						ok, reply := votes.add(discordSession, st, guildID, channelID, song,
+							control.user)
+						if !ok {
+							go trySend(control.returnChannel, reply, stdTimeout)
+							continue
+						}
 						mediaSession.stop()
+						endSong(true)
 
-						go trySend(control.returnChannel, "Song skipped.", stdTimeout)
+						go trySend(control.returnChannel, trackReply("Song skipped.", song), stdTimeout)
 
 						break controlLoop
 
-					case disconnect:
-						if !mediaSession.encode.Running() {
-							go trySend(control.returnChannel, "Not yet.", stdTimeout)
+					case disconnect, playerShutdown:
+						// The player cannot refuse to shut down.
+						if control.commandType == disconnect && !mediaSession.encode.Running() {
+							go trySend(control.returnChannel, errReply(StatusBusy, ErrNotReady, "Not yet."),
+								stdTimeout)
 							continue
 
 						}
+						reason = disconnectReason(control)
+						interrupted = interruptedTrack(song, mediaSession)
 						mediaSession.stop()
+						endSong(false)
 
-						go trySend(control.returnChannel, "Goodbye.", stdTimeout)
+						go trySend(control.returnChannel, okReply("Goodbye."), stdTimeout)
 
 						break mainLoop
 
+					case seek, forward, rewind:
+						if song.Duration() == 0 {
+							go trySend(control.returnChannel, errReply(StatusRejected, ErrInvalidRequest,
+								"Cannot seek a live stream."), stdTimeout)
+							continue
+						}
+						if !mediaSession.encode.Running() {
+							go trySend(control.returnChannel, errReply(StatusBusy, ErrNotReady, "Not yet."),
+								stdTimeout)
+							continue
+						}
+
+						pos, err := seekPosition(control, mediaSession.stream.PlaybackPos())
+						if err != nil {
+							go trySend(control.returnChannel, errReply(StatusInvalid, ErrInvalidRequest,
+								"Invalid time."), stdTimeout)
+							continue
+						}
+						if pos >= song.Duration() {
+							go trySend(control.returnChannel, errReply(StatusInvalid, ErrInvalidRequest,
+								"Cannot seek past the end of the song."), stdTimeout)
+							continue
+						}
+
+						paused := mediaSession.stream.Paused()
+						mediaSession.stop()
+						if err := restartSong(pos, paused); err != nil {
+							go trySend(control.returnChannel, errReply(StatusFailed, err,
+								"Couldn't seek, skipping the song."), stdTimeout)
+							break controlLoop
+						}
+						if !mediaSession.stream.Paused() {
+							stopTimer(disconnectTimer)
+						}
+
+						reply := trackReply(fmt.Sprintf("Playing from %v.", pos.Truncate(time.Second)),
+							song)
+						reply.Position = pos
+						go trySend(control.returnChannel, reply, stdTimeout)
+
+					case volume, normalize, filter:
+						var changed bool
+						var reply Response
+						if control.commandType == filter {
+							effects, changed, reply = effects.apply(control.commandData)
+						} else {
+							var settings store.AudioSettings
+							settings, changed, reply = updateAudioSettings(st, guildID,
+								control.commandType, control.commandData)
+							if changed {
+								audio = settings
+							}
+						}
+						if !changed {
+							go trySend(control.returnChannel, reply, stdTimeout)
+							continue
+						}
+						// The next song must be prepared again with the new settings.
+						if prefetched != nil {
+							next := prefetched.song
+							prefetched.cancel()
+							prefetched = prefetch(nil, next, vc, audio, effects)
+						}
+
+						if !mediaSession.encode.Running() {
+							reply.Message += " It will apply from the next song."
+							go trySend(control.returnChannel, reply, stdTimeout)
+							continue
+						}
+						// The song is restarted from the current position with the new settings.
+						// A live stream cannot be returned to, so it is restarted from now.
+						var pos time.Duration
+						if song.Duration() != 0 {
+							pos = mediaSession.stream.PlaybackPos()
+						}
+						paused := mediaSession.stream.Paused()
+						mediaSession.stop()
+						if err := restartSong(pos, paused); err != nil {
+							reply.Message += " Couldn't restart the song, skipping it."
+							go trySend(control.returnChannel, reply, stdTimeout)
+							break controlLoop
+						}
+						go trySend(control.returnChannel, reply, stdTimeout)
+
+					case remove, move, shuffle, clear, loop:
+						go sendQueueEdit(queue, control)
+
+					case savePlaylist:
+						qch := make(chan queueSnapshot)
+						queue.inspectSongQueue <- qch
+						q := <-qch
+						go trySend(control.returnChannel,
+							storePlaylist(st, control.commandData, &song, q.tracks), stdTimeout)
+
+					case nowPlaying:
+						go trySend(control.returnChannel,
+							describeCurrentSong(song, mediaSession), stdTimeout)
+
 					case inspect:
-						qch := make(chan []*yt.Video)
+						qch := make(chan queueSnapshot)
 						queue.inspectSongQueue <- qch
 						q := <-qch
-						songTimeRemaining := song.Duration - mediaSession.stream.PlaybackPos()
-						go trySend(control.returnChannel, prettySongList(q, songTimeRemaining), stdTimeout)
+						var list string
+						pos := mediaSession.stream.PlaybackPos()
+						if song.Duration() == 0 {
+							list = fmt.Sprintf("Now streaming: %s\n%s", song.Title(),
+								prettySongList(q, 0, false, effects.tempo()))
+						} else {
+							// The song may be playing at a different tempo to the one it was
+							// started with if the effects have been changed.
+							songTimeRemaining := song.Duration() - pos
+							songTimeRemaining = time.Duration(float64(songTimeRemaining) /
+								mediaSession.stream.tempo)
+							list = prettySongList(q, songTimeRemaining, true, effects.tempo())
+						}
+						reply := trackReply(list, song)
+						reply.Position = pos
+						for _, v := range q.tracks {
+							reply.Queue = append(reply.Queue, trackRef(v))
+						}
+						go trySend(control.returnChannel, reply, stdTimeout)
 					}
 				}
 			}
+			armIdle = true
 
 		case <-disconnectTimer.C:
 			mediaReturnRequestChan <- guildID
@@ -167,17 +692,152 @@ mainLoop:
 
 	}
 
-	// End queue goroutine and disconnect from voice channel before informing the coordinator
-	// that we have finished.
-	// TODO: I have implemented the potential for returning the queue after a session ends. This
-	//  could be recovered afterwards.
-	remainingQ := make(chan []*yt.Video)
+	// End queue goroutine, saving what is left of it, and disconnect from voice channel before
+	// informing the coordinator that we have finished.
+	prefetched.cancel()
+	remainingQ := make(chan []queuedTrack)
+	queue.cancel()
 	queue.shutdown <- remainingQ
-	<-remainingQ
+	saveQueue(st, guildID, channelID, reason, interrupted, <-remainingQ)
 	err = vc.Disconnect()
 	if err != nil {
 		log.Error().Err(err).Msg("")
 	}
+	events.publish(Event{Type: EventPlayerStopped, GuildID: guildID})
 	mediaReturnFinishChan <- guildID
 
 }
+
+// disconnectReason returns why a player told to disconnect by control shut down.
+func disconnectReason(control playerCommand) store.SaveReason {
+	if control.commandType == playerShutdown {
+		return store.SaveShutdown
+	}
+	return store.SaveDisconnect
+}
+
+// interruptedTrack returns the song being played by m, set to start from the current playback
+// position.
+func interruptedTrack(song queuedTrack, m *mediaSession) *queuedTrack {
+	song.start = m.stream.PlaybackPos()
+	return &song
+}
+
+// summonPlayer moves the voice connection to the channel of the user making a summon request,
+// returning the channel the player is in afterwards and a message describing the outcome for the
+// user. Users without privileges can only move the player if nobody is listening to it.
+func summonPlayer(s *dgo.Session, vc *dgo.VoiceConnection, guildID, channelID string,
+	control playerCommand) (string, Response) {
+
+	if control.channelID == channelID {
+		return channelID, okReply("Already in your channel.")
+	}
+	if !control.user.Privileged && len(channelListeners(s, guildID, channelID)) > 0 {
+		return channelID, errReply(StatusRejected, ErrNotPermitted,
+			"Only DJs can move the player while others are listening.")
+	}
+
+	err := vc.ChangeChannel(control.channelID, false, true)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't change voice channel")
+		return channelID, errReply(StatusFailed, err, "Couldn't join your channel.")
+	}
+	return control.channelID, okReply("Moved to your channel.")
+}
+
+// startMedia starts playing song from pos with the given audio settings and effects, pausing it
+// straight away if paused is true.
+func startMedia(song queuedTrack, vc *dgo.VoiceConnection, pos time.Duration, paused bool,
+	audio store.AudioSettings, effects audioEffects) (*mediaSession, error) {
+
+	m, err := newMediaSession(song, vc, pos, audio, effects)
+	if err != nil {
+		return nil, err
+	}
+	if err := vc.Speaking(true); err != nil {
+		log.Error().Err(err).Msg("")
+	}
+	m.stream.Start()
+	if paused {
+		m.pause()
+	}
+	return m, nil
+}
+
+// stopTimer stops t, draining its channel if it has already fired.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// resetTimer stops t and restarts it with duration d.
+func resetTimer(t *time.Timer, d time.Duration) {
+	stopTimer(t)
+	t.Reset(d)
+}
+
+// sendQueueEdit passes a queue modification command on to the song queue goroutine. The queue may
+// be busy resolving a request, so this should be run in its own goroutine.
+func sendQueueEdit(queue queueConfig, control playerCommand) {
+	edit, err := newQueueEdit(control)
+	if err != nil {
+		if control.commandType == loop {
+			trySend(control.returnChannel, errReply(StatusInvalid, ErrInvalidRequest,
+				"Loop mode must be one of off, track or queue."), stdTimeout)
+		} else {
+			trySend(control.returnChannel, errReply(StatusInvalid, ErrInvalidRequest,
+				"Invalid queue position."), stdTimeout)
+		}
+		return
+	}
+
+	timer := time.NewTimer(5 * time.Second)
+	select {
+	case queue.edit <- edit:
+		timer.Stop()
+	case <-timer.C:
+		trySend(control.returnChannel, errReply(StatusBusy, ErrServerBusy,
+			"Queue busy, please try again."), stdTimeout)
+	}
+}
+
+// seekPosition returns the position in the song requested by a seek, forward or rewind command,
+// given the current playback position.
+func seekPosition(control playerCommand, current time.Duration) (time.Duration, error) {
+	d, err := parseSeekTime(control.commandData)
+	if err != nil {
+		return 0, err
+	}
+
+	var pos time.Duration
+	switch control.commandType {
+	case forward:
+		pos = current + d
+	case rewind:
+		pos = current - d
+	default:
+		pos = d
+	}
+
+	if pos < 0 {
+		pos = 0
+	}
+	return pos, nil
+}
+
+// parseSeekTime parses a time given either as a clock time such as "1:23", a number of seconds,
+// or a Go duration such as "30s".
+func parseSeekTime(s string) (time.Duration, error) {
+	if d, err := parseClock(s); err == nil {
+		return d, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid time: %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,328 @@
+package media
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	soundSourceName = "soundboard"
+	// MaxSoundSize is the largest file which can be added to the soundboard, in bytes.
+	MaxSoundSize = 2 << 20
+	// MaxSoundDuration is the longest clip which can be added to the soundboard.
+	MaxSoundDuration = 20 * time.Second
+)
+
+var (
+	// ErrNoSoundboard is the error used when no soundboard directory has been set
+	ErrNoSoundboard = errors.New("soundboard not enabled")
+	// ErrSoundTooLarge is the error used when a clip is larger than MaxSoundSize
+	ErrSoundTooLarge = errors.New("sound file too large")
+	// ErrSoundTooLong is the error used when a clip is longer than MaxSoundDuration
+	ErrSoundTooLong = errors.New("sound too long")
+	// ErrInvalidSoundName is the error used when a clip name is not a single short word
+	ErrInvalidSoundName = errors.New("invalid sound name")
+)
+
+var soundNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var (
+	soundboardDir string
+	soundboardMu  sync.RWMutex
+)
+
+// SetSoundboardDir sets the directory in which soundboard clips are stored, enabling the
+// soundboard.
+func SetSoundboardDir(dir string) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	soundboardMu.Lock()
+	soundboardDir = dir
+	soundboardMu.Unlock()
+	return nil
+}
+
+func currentSoundboardDir() string {
+	soundboardMu.RLock()
+	defer soundboardMu.RUnlock()
+	return soundboardDir
+}
+
+// AddSound downloads the audio file at url and adds it to the guild's soundboard under name,
+// replacing any existing clip with that name. ext is the extension of the file, such as ".mp3".
+func AddSound(ctx context.Context, st store.Store, guildID, name, uploader, url,
+	ext string) error {
+
+	dir := currentSoundboardDir()
+	if dir == "" {
+		return ErrNoSoundboard
+	}
+	if !soundNamePattern.MatchString(name) {
+		return ErrInvalidSoundName
+	}
+
+	guildDir := filepath.Join(dir, guildID)
+	err := os.MkdirAll(guildDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(guildDir, "*"+cacheTempExt)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	err = downloadSound(ctx, url, f)
+	if err != nil {
+		return err
+	}
+
+	d, err := probeDuration(ctx, f.Name())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotAudio, err)
+	}
+	if d > MaxSoundDuration {
+		return ErrSoundTooLong
+	}
+
+	// Clips are named after their sound so that replacing a sound replaces its file, but the
+	// extension may differ from the previous file.
+	if old, err := st.GetSound(guildID, name); err == nil {
+		_ = os.Remove(filepath.Join(dir, old.File))
+	}
+
+	file := filepath.Join(guildID, name+strings.ToLower(ext))
+	err = os.Rename(f.Name(), filepath.Join(dir, file))
+	if err != nil {
+		return err
+	}
+
+	return st.AddSound(guildID, store.Sound{
+		Name:     name,
+		File:     file,
+		Uploader: uploader,
+		Duration: d,
+	})
+}
+
+// downloadSound copies the file at url to w, failing if it is larger than MaxSoundSize.
+func downloadSound(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+
+	n, err := io.Copy(w, io.LimitReader(resp.Body, MaxSoundSize+1))
+	if err != nil {
+		return err
+	}
+	if n > MaxSoundSize {
+		return ErrSoundTooLarge
+	}
+	return nil
+}
+
+// RemoveSound removes the named clip from the guild's soundboard.
+func RemoveSound(st store.Store, guildID, name string) error {
+	sound, err := st.GetSound(guildID, name)
+	if err != nil {
+		return err
+	}
+
+	err = st.DeleteSound(guildID, name)
+	if err != nil {
+		return err
+	}
+
+	if dir := currentSoundboardDir(); dir != "" {
+		err = os.Remove(filepath.Join(dir, sound.File))
+		if err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Msg("")
+		}
+	}
+	return nil
+}
+
+// loadSound returns the named clip from the guild's soundboard as a Track.
+func loadSound(st store.Store, guildID, name string) (Track, error) {
+	dir := currentSoundboardDir()
+	if dir == "" {
+		return nil, ErrNoSoundboard
+	}
+
+	sound, err := st.GetSound(guildID, strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &soundTrack{sound: sound, path: filepath.Join(dir, sound.File)}, nil
+}
+
+type soundTrack struct {
+	sound store.Sound
+	path  string
+}
+
+func (t *soundTrack) ID() string {
+	return t.sound.File
+}
+
+func (t *soundTrack) Title() string {
+	return t.sound.Name
+}
+
+func (t *soundTrack) Duration() time.Duration {
+	return t.sound.Duration
+}
+
+func (t *soundTrack) Source() string {
+	return soundSourceName
+}
+
+func (t *soundTrack) Open(context.Context) (io.ReadCloser, error) {
+	return os.Open(t.path)
+}
+
+// loadClip returns the clip requested by a soundboard or say command, if the guild's queue policy
+// allows it to be played.
+func loadClip(st store.Store, guildID string, control playerCommand) (Track, error) {
+	source := soundSourceName
+	if control.commandType == say {
+		source = speechSourceName
+	}
+	policy := loadQueuePolicy(st, guildID)
+	if !channelAllowed(policy, control.channelID) {
+		return nil, errChannelDenied
+	}
+	if !listAllows(policy.AllowedSources, policy.DeniedSources, source) {
+		return nil, errSourceDenied
+	}
+
+	if control.commandType == say {
+		return newSpeechTrack(control.commandData)
+	}
+	return loadSound(st, guildID, control.commandData)
+}
+
+// clipError returns the reply to a soundboard or say command whose clip could not be loaded.
+func clipError(err error) Response {
+	switch {
+	case errors.Is(err, ErrNoSoundboard):
+		return errReply(StatusRejected, err, "The soundboard is not enabled.")
+	case errors.Is(err, ErrNoSpeaker):
+		return errReply(StatusRejected, err, "Text to speech is not enabled.")
+	case errors.Is(err, ErrSpeechTooLong):
+		return errReply(StatusInvalid, err,
+			fmt.Sprintf("Text must be %d characters or fewer.", MaxSpeechLength))
+	case errors.Is(err, errChannelDenied):
+		return errReply(StatusRejected, err, "Sounds cannot be played from this channel.")
+	case errors.Is(err, errSourceDenied):
+		return errReply(StatusRejected, err, "This kind of sound is not allowed.")
+	case errors.Is(err, sql.ErrNoRows):
+		return errReply(StatusNotFound, ErrNotFound, "No such sound.")
+	default:
+		log.Error().Err(err).Msg("")
+		return errReply(StatusFailed, err, "Couldn't play sound.")
+	}
+}
+
+// clipStarted returns the reply to a soundboard or say command once its clip starts playing.
+func clipStarted(control playerCommand, clip Track) Response {
+	t := queuedTrack{Track: clip, requester: control.user.ID}
+	if control.commandType == say {
+		return trackReply("Speaking.", t)
+	}
+	return trackReply("Playing "+clip.Title()+".", t)
+}
+
+// startClip starts playing clip on vc.
+func startClip(vc *dgo.VoiceConnection, clip Track, audio store.AudioSettings) (*mediaSession,
+	error) {
+
+	m, err := newMediaSession(clip, vc, 0, audio, noEffects)
+	if err != nil {
+		return nil, err
+	}
+	if err := vc.Speaking(true); err != nil {
+		log.Error().Err(err).Msg("")
+	}
+	m.stream.Start()
+	return m, nil
+}
+
+// playSound plays clip on vc, returning once it has finished. Control messages are answered while
+// the clip plays: a skip stops it, a disconnect stops it and is returned so that the player can
+// shut down, and anything else is refused until the clip has finished. changed reports whether the
+// listeners in the voice channel changed in the meantime.
+func playSound(vc *dgo.VoiceConnection, clip Track, audio store.AudioSettings,
+	controlChannel <-chan playerCommand) (stop *playerCommand, changed bool, err error) {
+
+	m, err := startClip(vc, clip, audio)
+	if err != nil {
+		return nil, false, err
+	}
+	defer m.stop()
+	defer func() {
+		if err := vc.Speaking(false); err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}()
+
+	// The clip is abandoned if it somehow plays for much longer than it should.
+	timeout := time.NewTimer(2 * MaxSoundDuration)
+	defer timeout.Stop()
+	for {
+		select {
+		case err := <-m.stream.done:
+			if err == io.EOF {
+				err = nil
+			}
+			return nil, changed, err
+		case <-timeout.C:
+			return nil, changed, errors.New("sound timed out")
+		case control := <-controlChannel:
+			switch control.commandType {
+			case disconnect, playerShutdown:
+				return &control, changed, nil
+			case skip:
+				go trySend(control.returnChannel, okReply("Sound stopped."), stdTimeout)
+				return nil, changed, nil
+			case listenersChanged:
+				changed = true
+			default:
+				go trySend(control.returnChannel, errReply(StatusBusy, ErrNotReady, "Not yet."),
+					stdTimeout)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Track represents a single playable item which can be held in a song queue.
+type Track interface {
+	// ID returns an identifier for the track which is unique within its source.
+	ID() string
+	Title() string
+	// Duration returns the length of the track, or zero if the length is unknown.
+	Duration() time.Duration
+	// Source returns the name of the Source which resolved the track.
+	Source() string
+	// Open returns a reader for the raw audio data of the track. Cancelling ctx aborts the
+	// download.
+	Open(ctx context.Context) (io.ReadCloser, error)
+}
+
+// Source resolves song requests into Tracks.
+type Source interface {
+	Name() string
+	// Accepts reports whether the Source is able to resolve query.
+	Accepts(query string) bool
+	// Resolve returns the Tracks referred to by query. More than one Track is returned when
+	// the query refers to a collection, such as a directory.
+	Resolve(ctx context.Context, query string) ([]Track, error)
+}
+
+// identifier is implemented by Sources which can tell which single track a query refers to without
+// resolving it, so that the track can be played from the cache while the source is unreachable.
+type identifier interface {
+	// Identify returns the ID of the track query refers to, reporting whether it refers to one.
+	Identify(query string) (string, bool)
+}
+
+// ErrNoSource is the error used when no registered Source accepts a song request
+var ErrNoSource = errors.New("no source for request")
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = []Source{httpSource{}, youtubeSource{}}
+)
+
+// RegisterSource adds s to the sources used to resolve song requests.
+// Sources registered later take priority over those registered earlier, and the built-in
+// HTTP and YouTube sources are always tried last.
+func RegisterSource(s Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources = append([]Source{s}, sources...)
+}
+
+// resolve returns the Tracks from the first registered Source which accepts query.
+// If no Source accepts the query, it is treated as search terms and the top search result is
+// resolved instead.
+func resolve(ctx context.Context, query string) ([]Track, error) {
+	if s := sourceFor(query); s != nil {
+		return resolveWith(ctx, s, query)
+	}
+
+	results, err := Search(ctx, query, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNoResults
+	}
+
+	if s := sourceFor(results[0].URL); s != nil {
+		return resolveWith(ctx, s, results[0].URL)
+	}
+	return nil, ErrNoSource
+}
+
+// resolveWith returns the Tracks referred to by query from s, or the cached track if s can
+// identify it without resolving it.
+func resolveWith(ctx context.Context, s Source, query string) ([]Track, error) {
+	if i, ok := s.(identifier); ok {
+		if id, ok := i.Identify(query); ok {
+			if t, ok := cachedTrack(s.Name(), id); ok {
+				return []Track{t}, nil
+			}
+		}
+	}
+	return s.Resolve(ctx, query)
+}
+
+// sourceFor returns the first registered Source which accepts query, or nil if there is none.
+func sourceFor(query string) Source {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	for _, s := range sources {
+		if s.Accepts(query) {
+			return s
+		}
+	}
+	return nil
+}
+
+// sourceByName returns the registered Source with the given name, or nil if there is none.
+func sourceByName(name string) Source {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	for _, s := range sources {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// SourceNames returns the names of the registered sources, along with the soundboard and text to
+// speech, which tracks can also come from.
+func SourceNames() []string {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	names := make([]string, 0, len(sources)+2)
+	for _, s := range sources {
+		names = append(names, s.Name())
+	}
+	return append(names, soundSourceName, speechSourceName)
+}
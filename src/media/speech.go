@@ -0,0 +1,161 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	speechSourceName = "speech"
+	// MaxSpeechLength is the longest text which can be spoken, in characters.
+	MaxSpeechLength = 200
+)
+
+var (
+	// ErrNoSpeaker is the error used when no text to speech engine has been set
+	ErrNoSpeaker = errors.New("text to speech not enabled")
+	// ErrSpeechTooLong is the error used when text is longer than MaxSpeechLength
+	ErrSpeechTooLong = errors.New("text too long")
+)
+
+// Speaker synthesizes speech. Speakers must work offline, as they are run by a local engine.
+type Speaker interface {
+	// Speak returns audio of text being spoken, in any format which ffmpeg can read.
+	Speak(ctx context.Context, text string) (io.ReadCloser, error)
+}
+
+var (
+	speaker   Speaker
+	speakerMu sync.RWMutex
+)
+
+// SetSpeaker sets the Speaker used to say text, enabling text to speech.
+func SetSpeaker(s Speaker) {
+	speakerMu.Lock()
+	speaker = s
+	speakerMu.Unlock()
+}
+
+func currentSpeaker() Speaker {
+	speakerMu.RLock()
+	defer speakerMu.RUnlock()
+	return speaker
+}
+
+// commandSpeaker is a Speaker which runs a command, passing it the text on stdin and reading WAV
+// audio from stdout.
+type commandSpeaker struct {
+	name string
+	args []string
+}
+
+// NewEspeak returns a Speaker which runs espeak or espeak-ng. binary is the path of the executable.
+func NewEspeak(binary string) Speaker {
+	return commandSpeaker{name: binary, args: []string{"--stdout"}}
+}
+
+// NewPiper returns a Speaker which runs piper with the given voice model. binary is the path of the
+// executable.
+func NewPiper(binary, model string) Speaker {
+	return commandSpeaker{name: binary, args: []string{"--model", model, "--output_file", "-"}}
+}
+
+func (c commandSpeaker) Speak(ctx context.Context, text string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd.Stdin = strings.NewReader(text)
+	// Speech is short, so it is synthesized in full before being played.
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(out)), nil
+}
+
+// newSpeechTrack returns a Track of text being spoken by the current Speaker.
+func newSpeechTrack(text string) (Track, error) {
+	s := currentSpeaker()
+	if s == nil {
+		return nil, ErrNoSpeaker
+	}
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) > MaxSpeechLength {
+		return nil, ErrSpeechTooLong
+	}
+	return &speechTrack{speaker: s, text: text}, nil
+}
+
+// speechTrack is speech which is synthesized when it is opened.
+type speechTrack struct {
+	speaker Speaker
+	text    string
+}
+
+func (t *speechTrack) ID() string {
+	return t.text
+}
+
+func (t *speechTrack) Title() string {
+	return t.text
+}
+
+// Duration is unknown until the speech has been synthesized.
+func (t *speechTrack) Duration() time.Duration {
+	return 0
+}
+
+func (t *speechTrack) Source() string {
+	return speechSourceName
+}
+
+func (t *speechTrack) Open(ctx context.Context) (io.ReadCloser, error) {
+	return t.speaker.Speak(ctx, t.text)
+}
+
+// announceSong says the title of song on vc, if the guild has announcements enabled. Soundboard
+// clips and speech are not announced. Control messages are answered while the announcement plays,
+// as they are by playSound.
+func announceSong(st store.Store, guildID string, vc *dgo.VoiceConnection, song queuedTrack,
+	audio store.AudioSettings, controlChannel <-chan playerCommand) (stop *playerCommand,
+	changed bool) {
+
+	if song.Source() == soundSourceName || song.Source() == speechSourceName {
+		return nil, false
+	}
+	announce, err := st.GetAnnounce(guildID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Msg("")
+		}
+		return nil, false
+	}
+	if !announce {
+		return nil, false
+	}
+
+	s := currentSpeaker()
+	if s == nil {
+		return nil, false
+	}
+	title := []rune(song.Title())
+	if len(title) > MaxSpeechLength {
+		title = title[:MaxSpeechLength]
+	}
+	stop, changed, err = playSound(vc, &speechTrack{speaker: s, text: "Now playing " +
+		string(title)}, audio, controlChannel)
+	if err != nil {
+		log.Error().Err(err).Str("guildID", guildID).Msg("Couldn't announce song")
+	}
+	return stop, changed
+}
@@ -6,70 +6,145 @@ import (
 
 	dgo "github.com/bwmarrin/discordgo"
 	"github.com/jonas747/dca"
+	"github.com/rs/zerolog/log"
+)
+
+// silenceFrame is an Opus frame of silence.
+var silenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+const (
+	// silenceFrameCount is the number of silence frames sent when pausing, which prevents
+	// Discord from interpolating the last frames of audio.
+	silenceFrameCount = 5
+	// silenceInterval is how often a silence frame is sent while paused, keeping the voice
+	// connection active.
+	silenceInterval = time.Second
 )
 
 type streamSession struct {
 	vc *dgo.VoiceConnection
 
-	stop chan bool
-	done chan error
+	// stop is closed to end the stream, once only.
+	stop     chan bool
+	stopOnce sync.Once
+	done     chan error
+	unpause  chan bool
 
 	source     dca.OpusReader
 	framesSent int
+	// offset is the position in the song at which the source begins.
+	offset time.Duration
+	// tempo is the speed at which the song is played, used to convert the duration of the frames
+	// sent into a position in the song.
+	tempo float64
 
 	streaming bool
+	paused    bool
 
 	sync.RWMutex
 }
 
-func newStreamingSession(source dca.OpusReader, vc *dgo.VoiceConnection) *streamSession {
+func newStreamingSession(source dca.OpusReader, vc *dgo.VoiceConnection,
+	offset time.Duration, tempo float64) *streamSession {
 
 	session := &streamSession{
-		vc:     vc,
-		source: source,
-		done:   make(chan error),
-		stop:   make(chan bool),
+		vc:      vc,
+		source:  source,
+		offset:  offset,
+		tempo:   tempo,
+		done:    make(chan error),
+		stop:    make(chan bool),
+		unpause: make(chan bool, 1),
 	}
 
 	return session
 }
 
+// Start begins streaming in a new goroutine. The session is marked as streaming before Start
+// returns, so that it can be paused straight away.
 func (s *streamSession) Start() {
-	go s.stream()
-}
-
-func (s *streamSession) stream() {
-
 	s.Lock()
 	if s.streaming {
+		s.Unlock()
 		return
 	}
 	s.streaming = true
 	s.Unlock()
 
+	go s.stream()
+}
+
+func (s *streamSession) stream() {
+	defer func() {
+		s.Lock()
+		s.streaming = false
+		s.paused = false
+		s.Unlock()
+	}()
+
 	for {
 		select {
 		case <-s.stop:
-			s.Lock()
-			s.streaming = false
-			s.Unlock()
 			return
 		default:
 		}
+
+		if s.Paused() {
+			if stopped := s.waitPaused(); stopped {
+				return
+			}
+			continue
+		}
+
 		err := s.readNext()
 		if err != nil {
 			go func() {
 				s.done <- err
 			}()
-			// if err != io.EOF {
-			// }
-			s.Lock()
-			s.streaming = false
-			s.Unlock()
-			break
+			return
+		}
+	}
+
+}
+
+// waitPaused sends silence until the stream is resumed or stopped, reporting whether it was
+// stopped.
+func (s *streamSession) waitPaused() bool {
+	for i := 0; i < silenceFrameCount; i++ {
+		s.sendSilence()
+	}
+	if err := s.vc.Speaking(false); err != nil {
+		log.Error().Err(err).Msg("")
+	}
+
+	ticker := time.NewTicker(silenceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return true
+		case <-s.unpause:
+			// A stale signal may be left over if the stream was resumed before it had
+			// finished pausing.
+			if s.Paused() {
+				continue
+			}
+			if err := s.vc.Speaking(true); err != nil {
+				log.Error().Err(err).Msg("")
+			}
+			return false
+		case <-ticker.C:
+			s.sendSilence()
 		}
 	}
+}
 
+func (s *streamSession) sendSilence() {
+	select {
+	case s.vc.OpusSend <- silenceFrame:
+	case <-time.After(s.source.FrameDuration()):
+	}
 }
 
 func (s *streamSession) readNext() error {
@@ -100,33 +175,55 @@ func (s *streamSession) Streaming() bool {
 	return s.streaming
 }
 
-// Pause pauses the current streamSession.
-// Due to the nature of the streamSession, the only difference between pausing and
-// stopping a stream is that stopping is typically followed by the discarding of the
-// streamSession itself. Hence Pause is simply an alias of Stop.
-func (s *streamSession) Pause() bool {
-	return s.Stop()
+func (s *streamSession) Paused() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.paused
 }
 
-func (s *streamSession) Stop() bool {
-	if !s.Streaming() {
+// Pause pauses the current streamSession, reporting whether it was playing.
+// The stream stops reading frames from its source, but the source and voice connection are left
+// running so that it can be resumed from the same position.
+func (s *streamSession) Pause() bool {
+	s.Lock()
+	defer s.Unlock()
+	if !s.streaming || s.paused {
 		return false
 	}
-	s.stop <- true
+	s.paused = true
 	return true
 }
 
+// Stop ends the streamSession, after which it cannot be resumed, reporting whether it was
+// streaming. It never blocks, as the stream may end on its own at any time, and it is safe to call
+// more than once.
+func (s *streamSession) Stop() bool {
+	streaming := s.Streaming()
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	return streaming
+}
+
+// Resume resumes a paused streamSession, reporting whether it was paused.
 func (s *streamSession) Resume() bool {
-	if s.Streaming() {
+	s.Lock()
+	defer s.Unlock()
+	if !s.paused {
 		return false
 	}
+	s.paused = false
 
-	s.Start()
+	select {
+	case s.unpause <- true:
+	default:
+	}
 	return true
 }
 
 func (s *streamSession) PlaybackPos() time.Duration {
 	s.Lock()
 	defer s.Unlock()
-	return time.Duration(s.framesSent) * s.source.FrameDuration()
+	played := time.Duration(s.framesSent) * s.source.FrameDuration()
+	return s.offset + time.Duration(float64(played)*s.tempo)
 }
@@ -0,0 +1,98 @@
+package media
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/store"
+	"github.com/rs/zerolog/log"
+)
+
+// skipVote holds the votes to skip the song which is currently playing.
+type skipVote struct {
+	votes map[string]bool
+}
+
+func newSkipVote() *skipVote {
+	return &skipVote{votes: make(map[string]bool)}
+}
+
+// add registers a request by user to skip song, reporting whether the song should be skipped.
+// If it should not, the returned Response tells the user why.
+// Privileged users and the user who requested the song skip it immediately. Otherwise, if the guild
+// has vote skipping enabled, the song is skipped once the configured fraction of listeners in the
+// voice channel have voted for it.
+func (v *skipVote) add(s *dgo.Session, st store.Store, guildID, channelID string, song queuedTrack,
+	user User) (bool, Response) {
+
+	if user.Privileged || user.ID == song.requester {
+		return true, Response{}
+	}
+
+	settings, err := st.GetSkipSettings(guildID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Error().Err(err).Msg("")
+	}
+	if !settings.Vote {
+		return true, Response{}
+	}
+
+	listeners := channelListeners(s, guildID, channelID)
+	if !listeners[user.ID] {
+		return false, errReply(StatusRejected, ErrNotPermitted,
+			"You must be listening to vote to skip.")
+	}
+	v.votes[user.ID] = true
+
+	// Votes from users who have since left the channel no longer count.
+	count := 0
+	for id := range v.votes {
+		if listeners[id] {
+			count++
+		}
+	}
+
+	needed := int(math.Ceil(settings.Threshold * float64(len(listeners))))
+	if needed < 1 {
+		needed = 1
+	}
+	if count >= needed {
+		return true, Response{}
+	}
+	return false, trackReply(fmt.Sprintf("Skip vote registered, %d of %d needed.", count, needed),
+		song)
+}
+
+// channelListeners returns the set of users, other than bots, who are in the voice channel.
+func channelListeners(s *dgo.Session, guildID, channelID string) map[string]bool {
+	listeners := make(map[string]bool)
+
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return listeners
+	}
+
+	// The state is only locked while copying the voice states, as looking up a member locks it
+	// again.
+	var userIDs []string
+	s.State.RLock()
+	for _, v := range guild.VoiceStates {
+		if v.ChannelID == channelID && v.UserID != s.State.User.ID {
+			userIDs = append(userIDs, v.UserID)
+		}
+	}
+	s.State.RUnlock()
+
+	for _, id := range userIDs {
+		if member, err := s.State.Member(guildID, id); err == nil && member.User.Bot {
+			continue
+		}
+		listeners[id] = true
+	}
+
+	return listeners
+}
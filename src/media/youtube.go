@@ -0,0 +1,336 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	yt "github.com/kkdai/youtube/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const youtubeSourceName = "youtube"
+
+// youtubeSource resolves YouTube video and playlist URLs.
+type youtubeSource struct{}
+
+func (youtubeSource) Name() string {
+	return youtubeSourceName
+}
+
+// Accepts reports whether query is a YouTube URL. Anything else is assumed to be search terms,
+// including bare video IDs, which cannot be told apart from single words. Searching for an ID
+// finds its video anyway.
+func (youtubeSource) Accepts(query string) bool {
+	u, ok := youtubeURL(query)
+	if !ok {
+		return false
+	}
+	if isYoutubePlaylist(u) {
+		return true
+	}
+	_, err := yt.ExtractVideoID(u.String())
+	return err == nil
+}
+
+// Resolve returns the video or playlist entries linked to by query. Unlike Accepts, it also takes
+// a bare video ID, which is how saved tracks are resolved again.
+func (youtubeSource) Resolve(ctx context.Context, query string) ([]Track, error) {
+	client := yt.Client{}
+
+	u, ok := youtubeURL(query)
+	if ok {
+		query = u.String()
+	}
+
+	if ok && isYoutubePlaylist(u) {
+		playlist, err := client.GetPlaylistContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		if len(playlist.Videos) == 0 {
+			return nil, ErrNoResults
+		}
+
+		tracks := make([]Track, 0, len(playlist.Videos))
+		for _, v := range playlist.Videos {
+			tracks = append(tracks, &youtubeTrack{id: v.ID, title: v.Title, duration: v.Duration})
+		}
+		return tracks, nil
+	}
+
+	vid, err := client.GetVideoContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return []Track{newYoutubeTrack(vid)}, nil
+}
+
+// Identify returns the ID of the video linked to by query. Playlists are not identified, as they
+// refer to more than one video.
+func (youtubeSource) Identify(query string) (string, bool) {
+	u, ok := youtubeURL(query)
+	if !ok || isYoutubePlaylist(u) {
+		return "", false
+	}
+	id, err := yt.ExtractVideoID(u.String())
+	return id, err == nil
+}
+
+// youtubeURL parses query as a link to YouTube, reporting whether it is one.
+func youtubeURL(query string) (*url.URL, bool) {
+	query = strings.TrimSpace(query)
+	// Links are often pasted without their scheme, such as "youtu.be/...".
+	if !strings.Contains(query, "://") {
+		query = "https://" + query
+	}
+	u, err := url.Parse(query)
+	if err != nil || !strings.Contains(u.Hostname(), "youtu") {
+		return nil, false
+	}
+	return u, true
+}
+
+// isYoutubePlaylist reports whether u is a link to a playlist page. Links to a video within a
+// playlist are treated as links to the video alone.
+func isYoutubePlaylist(u *url.URL) bool {
+	return u.Path == "/playlist" && u.Query().Get("list") != ""
+}
+
+type youtubeTrack struct {
+	id       string
+	title    string
+	duration time.Duration
+
+	// video is nil for tracks which have not yet been fully resolved, such as playlist entries.
+	// These are resolved when they are opened.
+	video *yt.Video
+}
+
+func newYoutubeTrack(vid *yt.Video) *youtubeTrack {
+	return &youtubeTrack{id: vid.ID, title: vid.Title, duration: vid.Duration, video: vid}
+}
+
+func (t *youtubeTrack) ID() string {
+	return t.id
+}
+
+func (t *youtubeTrack) Title() string {
+	return t.title
+}
+
+func (t *youtubeTrack) Duration() time.Duration {
+	return t.duration
+}
+
+func (t *youtubeTrack) Source() string {
+	return youtubeSourceName
+}
+
+func (t *youtubeTrack) Open(ctx context.Context) (io.ReadCloser, error) {
+	client := yt.Client{}
+
+	video := t.video
+	if video == nil {
+		var err error
+		video, err = client.GetVideoContext(ctx, t.id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, v := range video.Formats {
+		log.Info().Msg(fmt.Sprintf("%v: %v", i, v.AudioQuality))
+	}
+	format, err := videoFormatFinder(video)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, length, err := client.GetStreamContext(ctx, video, &video.Formats[format])
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msg(fmt.Sprint(length))
+
+	return stream, nil
+}
+
+var audioQualities = map[string]int{
+	"AUDIO_QUALITY_LOW":    1,
+	"AUDIO_QUALITY_MEDIUM": 2,
+	"AUDIO_QUALITY_HIGH":   3,
+}
+
+func videoFormatFinder(vid *yt.Video) (int, error) {
+	highestSoFar := 0
+	highestQualityIndex := 0
+	for i, v := range vid.Formats {
+		if v.AudioChannels == 0 || v.AudioQuality == "" {
+			continue
+		}
+		if j, ok := audioQualities[v.AudioQuality]; ok && j > highestSoFar {
+			highestSoFar = j
+			highestQualityIndex = i
+		}
+	}
+
+	if len(vid.Formats) == 0 || vid.Formats[highestQualityIndex].AudioChannels == 0 {
+		return 0, errors.New("no audio found")
+	}
+
+	return highestQualityIndex, nil
+}
+
+const youtubeSearchURL = "https://www.youtube.com/results?search_query=%s&hl=en"
+
+// youtubeSearch is a SearchProvider which scrapes the YouTube search results page.
+type youtubeSearch struct{}
+
+func (youtubeSearch) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf(youtubeSearchURL, url.QueryEscape(query)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Language", "en")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The results are embedded in the page as a javascript object literal.
+	const prefix = "var ytInitialData = "
+	start := strings.Index(string(page), prefix)
+	if start < 0 {
+		return nil, errors.New("search results not found")
+	}
+
+	var data interface{}
+	err = json.NewDecoder(strings.NewReader(string(page[start+len(prefix):]))).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, v := range findVideoRenderers(data) {
+		if len(results) == limit {
+			break
+		}
+
+		id, _ := v["videoId"].(string)
+		title := youtubeRunsText(v["title"])
+		if id == "" || title == "" {
+			continue
+		}
+
+		var duration time.Duration
+		if length, ok := v["lengthText"].(map[string]interface{}); ok {
+			text, _ := length["simpleText"].(string)
+			duration, _ = parseClock(text)
+		}
+
+		results = append(results, SearchResult{
+			Title:    title,
+			Duration: duration,
+			URL:      "https://www.youtube.com/watch?v=" + id,
+		})
+	}
+
+	return results, nil
+}
+
+// findVideoRenderers returns the "videoRenderer" objects of the primary search results in data,
+// in the order they appear on the page. Videos in other parts of the page, such as adverts, are
+// ignored.
+func findVideoRenderers(data interface{}) []map[string]interface{} {
+	sections := youtubePath(data, "contents", "twoColumnSearchResultsRenderer", "primaryContents",
+		"sectionListRenderer", "contents")
+
+	var found []map[string]interface{}
+	for _, section := range youtubeArray(sections) {
+		items := youtubePath(section, "itemSectionRenderer", "contents")
+		for _, item := range youtubeArray(items) {
+			if r, ok := youtubePath(item, "videoRenderer").(map[string]interface{}); ok {
+				found = append(found, r)
+			}
+		}
+	}
+	return found
+}
+
+// youtubePath returns the value found by following keys through nested objects from v, or nil if
+// there is none.
+func youtubePath(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// youtubeArray returns v as an array, or nil if it is not one.
+func youtubeArray(v interface{}) []interface{} {
+	a, _ := v.([]interface{})
+	return a
+}
+
+// youtubeRunsText joins the text of a YouTube formatted string of the form {"runs":[{"text":...}]}.
+func youtubeRunsText(v interface{}) string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	runs, _ := m["runs"].([]interface{})
+
+	var sb strings.Builder
+	for _, r := range runs {
+		if run, ok := r.(map[string]interface{}); ok {
+			text, _ := run["text"].(string)
+			sb.WriteString(text)
+		}
+	}
+	return sb.String()
+}
+
+// parseClock parses a duration of the form "h:mm:ss", "m:ss" or "ss".
+func parseClock(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time: %q", s)
+	}
+
+	var d time.Duration
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time: %q", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+
+	return d * time.Second, nil
+}
@@ -0,0 +1,174 @@
+package strife
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/media"
+	"github.com/dpatterbee/strife/src/store"
+)
+
+const policySyntax = "Correct Syntax is: !policy tracklength|queuelength <duration>|off, " +
+	"!policy usertracks <number>|off, !policy sources|channels allow|deny <names>..., " +
+	"or !policy sources|channels any"
+
+// queuePolicy shows or changes the limits on what can be added to the guild's queue.
+func queuePolicy(_ *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	policy, err := bot.store.GetQueuePolicy(m.GuildID)
+	if err == sql.ErrNoRows {
+		policy = media.DefaultQueuePolicy
+	} else if err != nil {
+		return "", err
+	}
+
+	args := strings.Fields(data)
+	if len(args) == 0 {
+		return describePolicy(policy), nil
+	}
+	if len(args) < 2 {
+		return policySyntax, nil
+	}
+
+	switch args[0] {
+	case "tracklength", "queuelength":
+		d := time.Duration(0)
+		if args[1] != "off" {
+			d, err = time.ParseDuration(args[1])
+			if err != nil || d <= 0 {
+				return "Length must be a duration such as 10m or 2h, or off", nil
+			}
+		}
+		if args[0] == "tracklength" {
+			policy.MaxTrackLength = d
+		} else {
+			policy.MaxQueueDuration = d
+		}
+	case "usertracks":
+		n := 0
+		if args[1] != "off" {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return "Number of songs must be at least 1, or off", nil
+			}
+		}
+		policy.MaxUserTracks = n
+	case "sources":
+		var reply string
+		policy.AllowedSources, policy.DeniedSources, reply = updateList(policy.AllowedSources,
+			policy.DeniedSources, args[1:], validSources)
+		if reply != "" {
+			return reply, nil
+		}
+	case "channels":
+		var reply string
+		policy.AllowedChannels, policy.DeniedChannels, reply = updateList(policy.AllowedChannels,
+			policy.DeniedChannels, args[1:], validChannels(m.GuildID))
+		if reply != "" {
+			return reply, nil
+		}
+	default:
+		return policySyntax, nil
+	}
+
+	err = bot.store.SetQueuePolicy(m.GuildID, policy)
+	if err != nil {
+		return "", err
+	}
+
+	return "Queue policy successfully updated", nil
+}
+
+// updateList applies "allow <names>...", "deny <names>..." or "any" to an allow list and a deny
+// list, returning the new lists. If the arguments are invalid, the lists are unchanged and a reply
+// explaining why is returned. validate returns the normalized form of a name, or false if it is
+// not valid.
+func updateList(allowed, denied, args []string,
+	validate func(string) (string, bool)) ([]string, []string, string) {
+
+	if args[0] == "any" && len(args) == 1 {
+		return nil, nil, ""
+	}
+	if (args[0] != "allow" && args[0] != "deny") || len(args) == 1 {
+		return allowed, denied, policySyntax
+	}
+
+	var names []string
+	for _, v := range args[1:] {
+		name, ok := validate(v)
+		if !ok {
+			return allowed, denied, fmt.Sprintf("%s is not recognised", v)
+		}
+		names = append(names, name)
+	}
+	if args[0] == "allow" {
+		return names, denied, ""
+	}
+	return allowed, names, ""
+}
+
+func validSources(name string) (string, bool) {
+	name = strings.ToLower(name)
+	return name, in(name, media.SourceNames())
+}
+
+// validChannels returns a function which accepts the voice channels of the guild, given as
+// mentions or IDs.
+func validChannels(guildID string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		id := strings.TrimSuffix(strings.TrimPrefix(name, "<#"), ">")
+		channel, err := bot.session.State.Channel(id)
+		if err != nil || channel.GuildID != guildID || channel.Type != dgo.ChannelTypeGuildVoice {
+			return "", false
+		}
+		return id, true
+	}
+}
+
+func describePolicy(policy store.QueuePolicy) string {
+	var sb strings.Builder
+	sb.WriteString("Queue policy:")
+	limit := func(name string, set bool, value interface{}) {
+		if set {
+			_, _ = fmt.Fprintf(&sb, "\n%s: %v", name, value)
+		} else {
+			_, _ = fmt.Fprintf(&sb, "\n%s: no limit", name)
+		}
+	}
+	limit("Song length", policy.MaxTrackLength > 0, policy.MaxTrackLength)
+	limit("Songs per user", policy.MaxUserTracks > 0, policy.MaxUserTracks)
+	limit("Queue length", policy.MaxQueueDuration > 0, policy.MaxQueueDuration)
+
+	list := func(name string, allowed, denied []string, format func(string) string) {
+		_, _ = fmt.Fprintf(&sb, "\n%s: ", name)
+		if len(allowed) == 0 && len(denied) == 0 {
+			sb.WriteString("any")
+			return
+		}
+		var parts []string
+		if len(allowed) > 0 {
+			parts = append(parts, "only "+formatList(allowed, format))
+		}
+		if len(denied) > 0 {
+			parts = append(parts, "not "+formatList(denied, format))
+		}
+		sb.WriteString(strings.Join(parts, ", "))
+	}
+	list("Sources", policy.AllowedSources, policy.DeniedSources,
+		func(s string) string { return s })
+	list("Voice channels", policy.AllowedChannels, policy.DeniedChannels,
+		func(id string) string { return "<#" + id + ">" })
+
+	return sb.String()
+}
+
+func formatList(names []string, format func(string) string) string {
+	formatted := make([]string, len(names))
+	for i, v := range names {
+		formatted[i] = format(v)
+	}
+	return strings.Join(formatted, " ")
+}
@@ -0,0 +1,20 @@
+package strife
+
+import (
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/media"
+)
+
+// recordCommand starts recording the user's voice channel, reporting the recording to the text
+// channel the command was sent from, or stops the guild's recording.
+func recordCommand(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	switch data {
+	case "start":
+		return mediaCommand(s, m, media.RECORD, "start "+m.ChannelID)
+	case "stop":
+		// A recording can be stopped from outside of the voice channel.
+		return audioCommand(s, m, media.RECORD, "stop")
+	default:
+		return "Correct Syntax is: !record start|stop", nil
+	}
+}
@@ -1,12 +1,15 @@
 package strife
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -27,8 +30,15 @@ type strifeBot struct {
 
 const stdTimeout = time.Millisecond * 500
 
+// defaultCacheSizeMB is the maximum size of the audio cache if CACHE_SIZE_MB is not set.
+const defaultCacheSizeMB = 1024
+
 var bot strifeBot
 
+// resumeOnce ensures saved queues are only resumed on the first ready event, rather than again
+// every time the gateway reconnects.
+var resumeOnce sync.Once
+
 // Run starts strife
 func Run() int {
 
@@ -54,6 +64,7 @@ func Run() int {
 	bot.session.AddHandler(messageCreate)
 	bot.session.AddHandler(guildRoleCreate)
 	bot.session.AddHandler(guildRoleUpdate)
+	bot.session.AddHandler(voiceStateUpdate)
 
 	bot.session.Identify.Intents = dgo.IntentsGuilds | dgo.IntentsGuildMessages |
 		dgo.IntentsGuildVoiceStates
@@ -82,6 +93,12 @@ func Run() int {
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 	fmt.Print("\n")
+
+	log.Info().Msg("Saving media queues")
+	err = bot.mediaController.Shutdown(10 * time.Second)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+	}
 	return 0
 }
 
@@ -102,7 +119,78 @@ func (b *strifeBot) new() error {
 	log.Info().Msg("Getting database")
 	b.store = sqlite.New()
 
-	b.mediaController = media.New(b.session)
+	if libraryDir := os.Getenv("MEDIA_LIBRARY"); libraryDir != "" {
+		log.Info().Str("dir", libraryDir).Msg("Registering media library")
+		media.RegisterSource(media.NewLibrarySource(libraryDir))
+	}
+
+	if limit := os.Getenv("PLAYLIST_LIMIT"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid playlist limit: %v", limit)
+		}
+		media.SetPlaylistLimit(n)
+	}
+
+	if soundDir := os.Getenv("SOUNDBOARD_DIR"); soundDir != "" {
+		log.Info().Str("dir", soundDir).Msg("Enabling soundboard")
+		err := media.SetSoundboardDir(soundDir)
+		if err != nil {
+			return fmt.Errorf("error enabling soundboard: %v", err)
+		}
+	}
+
+	if cacheDir := os.Getenv("CACHE_DIR"); cacheDir != "" {
+		sizeMB := defaultCacheSizeMB
+		if size := os.Getenv("CACHE_SIZE_MB"); size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid cache size: %v", size)
+			}
+			sizeMB = n
+		}
+		log.Info().Str("dir", cacheDir).Int("sizeMB", sizeMB).Msg("Enabling audio cache")
+		err := media.EnableCache(cacheDir, int64(sizeMB)<<20)
+		if err != nil {
+			return fmt.Errorf("error enabling audio cache: %v", err)
+		}
+	}
+
+	if recordingDir := os.Getenv("RECORDING_DIR"); recordingDir != "" {
+		log.Info().Str("dir", recordingDir).Msg("Saving recordings")
+		err := media.SetRecordingDir(recordingDir)
+		if err != nil {
+			return fmt.Errorf("error setting recording directory: %v", err)
+		}
+	}
+
+	switch engine := os.Getenv("TTS_ENGINE"); engine {
+	case "":
+	case "espeak":
+		binary := os.Getenv("TTS_BINARY")
+		if binary == "" {
+			binary = "espeak-ng"
+		}
+		log.Info().Str("binary", binary).Msg("Enabling text to speech")
+		media.SetSpeaker(media.NewEspeak(binary))
+	case "piper":
+		binary := os.Getenv("TTS_BINARY")
+		if binary == "" {
+			binary = "piper"
+		}
+		model := os.Getenv("PIPER_MODEL")
+		if model == "" {
+			return errors.New("no piper voice model provided")
+		}
+		log.Info().Str("binary", binary).Str("model", model).Msg("Enabling text to speech")
+		media.SetSpeaker(media.NewPiper(binary, model))
+	default:
+		return fmt.Errorf("invalid text to speech engine: %v", engine)
+	}
+
+	b.mediaController = media.New(b.session, b.store)
+	events, _ := b.mediaController.Subscribe(16)
+	go reportFailedTracks(b.session, events)
 
 	return nil
 }
@@ -139,12 +227,73 @@ func ready(s *dgo.Session, r *dgo.Ready) {
 		}
 	}
 
+	if os.Getenv("AUTO_RESUME") != "" {
+		botID := s.State.User.ID
+		resumeOnce.Do(func() {
+			go resumeQueues(botID)
+		})
+	}
+
 	err := s.UpdateGameStatus(0, "dev")
 	if err != nil {
 		log.Error().Err(err).Msg("")
 	}
 }
 
+// resumeQueues restores the saved queue of every guild into the voice channel it was playing in.
+// Queues saved because a user told the player to leave are not resumed.
+func resumeQueues(botID string) {
+	queues, err := bot.store.GetAllQueues()
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return
+	}
+
+	user := media.User{ID: botID, Privileged: true}
+	for guildID, q := range queues {
+		if q.Reason == store.SaveDisconnect {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+		response, err := bot.mediaController.Send(ctx, guildID, q.ChannelID, user, media.RESTORE,
+			"")
+		cancel()
+		if err == nil && response.Status != media.StatusOK &&
+			response.Status != media.StatusTimeout {
+			err = response.Err
+		}
+		if err != nil {
+			log.Error().Err(err).Str("guildID", guildID).Msg("Couldn't resume queue")
+			continue
+		}
+		log.Info().Str("guildID", guildID).Str("response", response.Message).Msg("Resumed queue")
+	}
+}
+
+// reportFailedTracks tells the requester of each song which could not be played about it by direct
+// message, as the player has no text channel of its own.
+func reportFailedTracks(s *dgo.Session, events <-chan media.Event) {
+	for e := range events {
+		if e.Type != media.EventTrackFailed || e.Track == nil || e.Track.Requester == "" {
+			continue
+		}
+		// Songs restored by the bot itself have nobody to tell.
+		if s.State.User != nil && e.Track.Requester == s.State.User.ID {
+			continue
+		}
+		channel, err := s.UserChannelCreate(e.Track.Requester)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		_, err = s.ChannelMessageSend(channel.ID, fmt.Sprintf(
+			"**Couldn't play %s, it may no longer be available.**", e.Track.Title))
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}
+}
+
 func in(s string, ss []string) bool {
 	for _, v := range ss {
 		if s == v {
@@ -174,12 +323,20 @@ func guildRoleUpdate(_ *dgo.Session, r *dgo.GuildRoleUpdate) {
 	}
 }
 
+func voiceStateUpdate(_ *dgo.Session, v *dgo.VoiceStateUpdate) {
+	bot.mediaController.VoiceStateUpdate(v.GuildID)
+}
+
 func messageCreate(s *dgo.Session, m *dgo.MessageCreate) {
 
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
 
+	if deliverSelection(m) {
+		return
+	}
+
 	prefix, err := bot.store.GetPrefix(m.GuildID)
 	if err != nil {
 		return
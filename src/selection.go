@@ -0,0 +1,71 @@
+package strife
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+// pendingSelections holds the channels of commands which are waiting for a user to reply with a
+// numbered choice, keyed by channel and user ID.
+var pendingSelections = struct {
+	m map[string]chan int
+	sync.Mutex
+}{m: make(map[string]chan int)}
+
+func selectionKey(channelID, userID string) string {
+	return channelID + ":" + userID
+}
+
+// awaitSelection waits for the user to reply in the channel with a number, returning false if no
+// number is received before timeout.
+func awaitSelection(channelID, userID string, timeout time.Duration) (int, bool) {
+	key := selectionKey(channelID, userID)
+	ch := make(chan int, 1)
+
+	pendingSelections.Lock()
+	pendingSelections.m[key] = ch
+	pendingSelections.Unlock()
+
+	defer func() {
+		pendingSelections.Lock()
+		if pendingSelections.m[key] == ch {
+			delete(pendingSelections.m, key)
+		}
+		pendingSelections.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	select {
+	case n := <-ch:
+		timer.Stop()
+		return n, true
+	case <-timer.C:
+		return 0, false
+	}
+}
+
+// deliverSelection passes the message to a command waiting on a selection from its author,
+// reporting whether the message was consumed.
+func deliverSelection(m *dgo.MessageCreate) bool {
+	n, err := strconv.Atoi(strings.TrimSpace(m.Content))
+	if err != nil {
+		return false
+	}
+
+	pendingSelections.Lock()
+	defer pendingSelections.Unlock()
+
+	ch, ok := pendingSelections.m[selectionKey(m.ChannelID, m.Author.ID)]
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- n:
+	default:
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package strife
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/media"
+)
+
+const soundSyntax = "Correct Syntax is: !sound add <name> with an audio file attached, " +
+	"!sound remove <name>, or !sound list"
+
+// soundCommand manages the guild's soundboard. Anyone can list the clips, but only moderators can
+// add or remove them.
+func soundCommand(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	args := strings.Fields(strings.ToLower(data))
+	if len(args) == 0 {
+		return soundSyntax, nil
+	}
+
+	if args[0] == "list" {
+		return listSounds(m)
+	}
+
+	if len(args) != 2 || (args[0] != "add" && args[0] != "remove") {
+		return soundSyntax, nil
+	}
+	if userPermissionLevel(s, m) < botmoderator {
+		return "Only moderators can change the soundboard.", nil
+	}
+	name := args[1]
+
+	if args[0] == "remove" {
+		err := media.RemoveSound(bot.store, m.GuildID, name)
+		if err == sql.ErrNoRows {
+			return fmt.Sprintf("Sound \"%v\" doesn't exist", name), nil
+		} else if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Sound \"%v\" successfully removed!", name), nil
+	}
+
+	if len(m.Attachments) != 1 {
+		return "Attach one audio file to add it to the soundboard.", nil
+	}
+	a := m.Attachments[0]
+	if a.Size > media.MaxSoundSize {
+		return fmt.Sprintf("Sounds must be smaller than %d MB.", media.MaxSoundSize>>20), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := media.AddSound(ctx, bot.store, m.GuildID, name, m.Author.ID, a.URL,
+		filepath.Ext(a.Filename))
+	switch {
+	case errors.Is(err, media.ErrNoSoundboard):
+		return "The soundboard is not enabled.", nil
+	case errors.Is(err, media.ErrInvalidSoundName):
+		return "Sound names must be a single word of up to 32 letters, numbers, - or _.", nil
+	case errors.Is(err, media.ErrSoundTooLarge):
+		return fmt.Sprintf("Sounds must be smaller than %d MB.", media.MaxSoundSize>>20), nil
+	case errors.Is(err, media.ErrSoundTooLong):
+		return fmt.Sprintf("Sounds must be shorter than %v.", media.MaxSoundDuration), nil
+	case errors.Is(err, media.ErrNotAudio):
+		return "That file is not audio.", nil
+	case err != nil:
+		return "", err
+	}
+
+	return fmt.Sprintf("Sound \"%v\" successfully added!", name), nil
+}
+
+func listSounds(m *dgo.MessageCreate) (string, error) {
+	sounds, err := bot.store.GetSounds(m.GuildID)
+	if err != nil {
+		return "", err
+	}
+	if len(sounds) == 0 {
+		return "Soundboard is empty", nil
+	}
+
+	names := make([]string, 0, len(sounds))
+	for _, v := range sounds {
+		names = append(names, v.Name)
+	}
+	return "Sounds: " + strings.Join(names, ", "), nil
+}
+
+func playSoundboard(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if data == "" {
+		return "Correct Syntax is: !sb <name>", nil
+	}
+	return mediaCommand(s, m, media.SOUNDBOARD, data)
+}
@@ -0,0 +1,39 @@
+package strife
+
+import (
+	"database/sql"
+	"strings"
+
+	dgo "github.com/bwmarrin/discordgo"
+	"github.com/dpatterbee/strife/src/media"
+)
+
+func saySpeech(s *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	if strings.TrimSpace(data) == "" {
+		return "Correct Syntax is: !say <text>", nil
+	}
+	return mediaCommand(s, m, media.SAY, data)
+}
+
+// announceSongs shows or sets whether the title of each song is spoken before it plays.
+func announceSongs(_ *dgo.Session, m *dgo.MessageCreate, data string) (string, error) {
+	switch data {
+	case "":
+		announce, err := bot.store.GetAnnounce(m.GuildID)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		if announce {
+			return "Songs are announced before they play", nil
+		}
+		return "Songs are not announced", nil
+	case "on", "off":
+		err := bot.store.SetAnnounce(m.GuildID, data == "on")
+		if err != nil {
+			return "", err
+		}
+		return "Announcements turned " + data, nil
+	default:
+		return "Correct Syntax is: !announce on|off", nil
+	}
+}
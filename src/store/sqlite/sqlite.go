@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dpatterbee/strife/src/store"
 	"github.com/rs/zerolog/log"
@@ -68,6 +70,179 @@ func New() store.Store {
 		log.Fatal().Err(err).Msg("")
 	}
 
+	_, err = ctx.Exec(
+		`create table if not exists skipSettings(
+					guildID		text,
+					vote		integer,
+					threshold	real,
+				constraint skip_settings_pk
+					primary key(guildID)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists audioSettings(
+					guildID		text,
+					volume		integer,
+					normalize	integer,
+				constraint audio_settings_pk
+					primary key(guildID)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists idleSettings(
+					guildID			text,
+					queueTimeout	integer,
+					aloneTimeout	integer,
+				constraint idle_settings_pk
+					primary key(guildID)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists queuePolicies(
+					guildID				text,
+					maxTrackLength		integer,
+					maxUserTracks		integer,
+					maxQueueDuration	integer,
+					allowedSources		text,
+					deniedSources		text,
+					allowedChannels		text,
+					deniedChannels		text,
+				constraint queue_policies_pk
+					primary key(guildID)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists announceSettings(
+					guildID		text,
+					announce	integer,
+				constraint announce_settings_pk
+					primary key(guildID)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists queues(
+					guildID		text,
+					channelID	text,
+					position	integer,
+					reason		integer,
+				constraint queue_pk
+					primary key(guildID)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists queueTracks(
+					guildID		text,
+					idx			integer,
+					source		text,
+					trackID		text,
+					title		text,
+					duration	integer,
+					requester	text,
+				constraint queue_track_pk
+					primary key(guildID, idx)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists playlistTracks(
+					ownerID		text,
+					name		text,
+					idx			integer,
+					source		text,
+					trackID		text,
+					title		text,
+					duration	integer,
+				constraint playlist_track_pk
+					primary key(ownerID, name, idx)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists sounds(
+					guildID		text,
+					name		text,
+					file		text,
+					uploader	text,
+					duration	integer,
+				constraint sound_pk
+					primary key(guildID, name)
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	_, err = ctx.Exec(
+		`create table if not exists plays(
+					id			integer primary key autoincrement,
+					guildID		text,
+					source		text,
+					trackID		text,
+					title		text,
+					duration	integer,
+					requester	text,
+					started		integer,
+					ended		integer,
+					skipped		integer
+				);`,
+	)
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	// The play history grows without bound, so it is indexed for the history and stats commands,
+	// which look at a single guild.
+	for _, index := range []string{
+		`create index if not exists plays_guild_started on plays(guildID, started);`,
+		`create index if not exists plays_guild_requester on plays(guildID, requester);`,
+		`create index if not exists plays_guild_track on plays(guildID, source, trackID);`,
+	} {
+		_, err = ctx.Exec(index)
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+	}
+
 	return &db{
 		ctx: ctx,
 	}
@@ -268,3 +443,681 @@ func (d *db) GetName(guildID string) (string, error) {
 	return name, nil
 
 }
+
+// SetSkipSettings stores the skip settings of the server
+func (d *db) SetSkipSettings(guildID string, settings store.SkipSettings) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec(
+		"INSERT OR REPLACE INTO skipSettings(guildID, vote, threshold) VALUES (?,?,?)",
+		guildID, settings.Vote, settings.Threshold,
+	)
+
+	return err
+}
+
+// GetSkipSettings gets the skip settings of the server, returning sql.ErrNoRows if they have not
+// been set
+func (d *db) GetSkipSettings(guildID string) (store.SkipSettings, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var settings store.SkipSettings
+	err := d.ctx.QueryRow("SELECT vote, threshold FROM skipSettings WHERE guildID = ?",
+		guildID).Scan(&settings.Vote, &settings.Threshold)
+	if err != nil {
+		return store.SkipSettings{}, err
+	}
+
+	return settings, nil
+}
+
+// SetAudioSettings stores the audio settings of the server
+func (d *db) SetAudioSettings(guildID string, settings store.AudioSettings) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec(
+		"INSERT OR REPLACE INTO audioSettings(guildID, volume, normalize) VALUES (?,?,?)",
+		guildID, settings.Volume, settings.Normalize,
+	)
+
+	return err
+}
+
+// GetAudioSettings gets the audio settings of the server, returning sql.ErrNoRows if they have not
+// been set
+func (d *db) GetAudioSettings(guildID string) (store.AudioSettings, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var settings store.AudioSettings
+	err := d.ctx.QueryRow("SELECT volume, normalize FROM audioSettings WHERE guildID = ?",
+		guildID).Scan(&settings.Volume, &settings.Normalize)
+	if err != nil {
+		return store.AudioSettings{}, err
+	}
+
+	return settings, nil
+}
+
+// SetIdleSettings stores the idle settings of the server
+func (d *db) SetIdleSettings(guildID string, settings store.IdleSettings) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec(
+		"INSERT OR REPLACE INTO idleSettings(guildID, queueTimeout, aloneTimeout) VALUES (?,?,?)",
+		guildID, int64(settings.QueueTimeout), int64(settings.AloneTimeout),
+	)
+
+	return err
+}
+
+// GetIdleSettings gets the idle settings of the server, returning sql.ErrNoRows if they have not
+// been set
+func (d *db) GetIdleSettings(guildID string) (store.IdleSettings, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var queueTimeout, aloneTimeout int64
+	err := d.ctx.QueryRow("SELECT queueTimeout, aloneTimeout FROM idleSettings WHERE guildID = ?",
+		guildID).Scan(&queueTimeout, &aloneTimeout)
+	if err != nil {
+		return store.IdleSettings{}, err
+	}
+
+	return store.IdleSettings{
+		QueueTimeout: time.Duration(queueTimeout),
+		AloneTimeout: time.Duration(aloneTimeout),
+	}, nil
+}
+
+// SetQueuePolicy stores the queue policy of the server
+func (d *db) SetQueuePolicy(guildID string, policy store.QueuePolicy) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec(
+		`INSERT OR REPLACE INTO queuePolicies(guildID, maxTrackLength, maxUserTracks,
+			maxQueueDuration, allowedSources, deniedSources, allowedChannels, deniedChannels)
+			VALUES (?,?,?,?,?,?,?,?)`,
+		guildID, int64(policy.MaxTrackLength), policy.MaxUserTracks,
+		int64(policy.MaxQueueDuration), joinList(policy.AllowedSources),
+		joinList(policy.DeniedSources), joinList(policy.AllowedChannels),
+		joinList(policy.DeniedChannels),
+	)
+
+	return err
+}
+
+// GetQueuePolicy gets the queue policy of the server, returning sql.ErrNoRows if it has not
+// been set
+func (d *db) GetQueuePolicy(guildID string) (store.QueuePolicy, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var maxTrackLength, maxQueueDuration int64
+	var maxUserTracks int
+	var allowedSources, deniedSources, allowedChannels, deniedChannels string
+	err := d.ctx.QueryRow(
+		`SELECT maxTrackLength, maxUserTracks, maxQueueDuration, allowedSources, deniedSources,
+			allowedChannels, deniedChannels FROM queuePolicies WHERE guildID = ?`,
+		guildID).Scan(&maxTrackLength, &maxUserTracks, &maxQueueDuration, &allowedSources,
+		&deniedSources, &allowedChannels, &deniedChannels)
+	if err != nil {
+		return store.QueuePolicy{}, err
+	}
+
+	return store.QueuePolicy{
+		MaxTrackLength:   time.Duration(maxTrackLength),
+		MaxUserTracks:    maxUserTracks,
+		MaxQueueDuration: time.Duration(maxQueueDuration),
+		AllowedSources:   splitList(allowedSources),
+		DeniedSources:    splitList(deniedSources),
+		AllowedChannels:  splitList(allowedChannels),
+		DeniedChannels:   splitList(deniedChannels),
+	}, nil
+}
+
+// SetAnnounce stores whether the server announces each song before it plays
+func (d *db) SetAnnounce(guildID string, announce bool) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec(
+		"INSERT OR REPLACE INTO announceSettings(guildID, announce) VALUES (?,?)",
+		guildID, announce,
+	)
+
+	return err
+}
+
+// GetAnnounce gets whether the server announces each song before it plays, returning
+// sql.ErrNoRows if it has not been set
+func (d *db) GetAnnounce(guildID string) (bool, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var announce bool
+	err := d.ctx.QueryRow("SELECT announce FROM announceSettings WHERE guildID = ?",
+		guildID).Scan(&announce)
+
+	return announce, err
+}
+
+// SaveQueue replaces the saved queue of the specified guild
+func (d *db) SaveQueue(guildID string, queue store.SavedQueue) error {
+	d.Lock()
+	defer d.Unlock()
+
+	tx, err := d.ctx.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM queueTracks WHERE guildID = ?", guildID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO queues(guildID, channelID, position, reason) VALUES (?,?,?,?)",
+		guildID, queue.ChannelID, int64(queue.Position), int(queue.Reason),
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for i, v := range queue.Tracks {
+		_, err = tx.Exec(
+			`INSERT INTO queueTracks(guildID, idx, source, trackID, title, duration, requester)
+			VALUES (?,?,?,?,?,?,?)`,
+			guildID, i, v.Source, v.ID, v.Title, int64(v.Duration), v.Requester,
+		)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetQueue gets the saved queue of the specified guild, returning sql.ErrNoRows if there is none
+func (d *db) GetQueue(guildID string) (store.SavedQueue, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var queue store.SavedQueue
+	var position int64
+	err := d.ctx.QueryRow("SELECT channelID, position, reason FROM queues WHERE guildID = ?",
+		guildID).Scan(&queue.ChannelID, &position, &queue.Reason)
+	if err != nil {
+		return store.SavedQueue{}, err
+	}
+	queue.Position = time.Duration(position)
+
+	queue.Tracks, err = d.queueTracks(guildID)
+	if err != nil {
+		return store.SavedQueue{}, err
+	}
+
+	return queue, nil
+}
+
+// GetAllQueues gets every saved queue, keyed by guild ID
+func (d *db) GetAllQueues() (map[string]store.SavedQueue, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	rows, err := d.ctx.Query("SELECT guildID, channelID, position, reason FROM queues")
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[string]store.SavedQueue)
+	for rows.Next() {
+		var guildID string
+		var queue store.SavedQueue
+		var position int64
+		if err := rows.Scan(&guildID, &queue.ChannelID, &position, &queue.Reason); err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		queue.Position = time.Duration(position)
+		queues[guildID] = queue
+	}
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for guildID, queue := range queues {
+		queue.Tracks, err = d.queueTracks(guildID)
+		if err != nil {
+			return nil, err
+		}
+		queues[guildID] = queue
+	}
+
+	return queues, nil
+}
+
+func (d *db) queueTracks(guildID string) ([]store.TrackRef, error) {
+	rows, err := d.ctx.Query(
+		`SELECT source, trackID, title, duration, requester FROM queueTracks
+		WHERE guildID = ? ORDER BY idx`, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}(rows)
+
+	var tracks []store.TrackRef
+	for rows.Next() {
+		var t store.TrackRef
+		var duration int64
+		if err := rows.Scan(&t.Source, &t.ID, &t.Title, &duration, &t.Requester); err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		t.Duration = time.Duration(duration)
+		tracks = append(tracks, t)
+	}
+
+	return tracks, nil
+}
+
+// DeleteQueue removes the saved queue of the specified guild
+func (d *db) DeleteQueue(guildID string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	_, err := d.ctx.Exec("DELETE FROM queueTracks WHERE guildID = ?", guildID)
+	if err != nil {
+		return err
+	}
+	_, err = d.ctx.Exec("DELETE FROM queues WHERE guildID = ?", guildID)
+	return err
+}
+
+// SavePlaylist creates or replaces the named playlist belonging to the owner, which may be either
+// a user or a guild
+func (d *db) SavePlaylist(ownerID, name string, tracks []store.TrackRef) error {
+	d.Lock()
+	defer d.Unlock()
+
+	tx, err := d.ctx.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM playlistTracks WHERE ownerID = ? AND name = ?", ownerID, name)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = insertPlaylistTracks(tx, ownerID, name, 0, tracks)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddToPlaylist appends tracks to the named playlist, creating it if it does not exist
+func (d *db) AddToPlaylist(ownerID, name string, tracks []store.TrackRef) error {
+	d.Lock()
+	defer d.Unlock()
+
+	tx, err := d.ctx.Begin()
+	if err != nil {
+		return err
+	}
+
+	var next int
+	err = tx.QueryRow(
+		"SELECT COALESCE(MAX(idx) + 1, 0) FROM playlistTracks WHERE ownerID = ? AND name = ?",
+		ownerID, name).Scan(&next)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = insertPlaylistTracks(tx, ownerID, name, next, tracks)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertPlaylistTracks(tx *sql.Tx, ownerID, name string, start int,
+	tracks []store.TrackRef) error {
+
+	for i, v := range tracks {
+		_, err := tx.Exec(
+			`INSERT INTO playlistTracks(ownerID, name, idx, source, trackID, title, duration)
+			VALUES (?,?,?,?,?,?,?)`,
+			ownerID, name, start+i, v.Source, v.ID, v.Title, int64(v.Duration),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPlaylist gets the tracks of the named playlist, returning sql.ErrNoRows if it does not exist
+func (d *db) GetPlaylist(ownerID, name string) ([]store.TrackRef, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	rows, err := d.ctx.Query(
+		`SELECT source, trackID, title, duration FROM playlistTracks
+		WHERE ownerID = ? AND name = ? ORDER BY idx`, ownerID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}(rows)
+
+	var tracks []store.TrackRef
+	for rows.Next() {
+		var t store.TrackRef
+		var duration int64
+		if err := rows.Scan(&t.Source, &t.ID, &t.Title, &duration); err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		t.Duration = time.Duration(duration)
+		tracks = append(tracks, t)
+	}
+
+	if len(tracks) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return tracks, nil
+}
+
+// GetPlaylists gets the names of every playlist belonging to the owner
+func (d *db) GetPlaylists(ownerID string) ([]string, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	rows, err := d.ctx.Query(
+		"SELECT DISTINCT name FROM playlistTracks WHERE ownerID = ? ORDER BY name", ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}(rows)
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// DeletePlaylist removes the named playlist
+func (d *db) DeletePlaylist(ownerID, name string) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec("DELETE FROM playlistTracks WHERE ownerID = ? AND name = ?",
+		ownerID, name)
+	return err
+}
+
+// AddSound inserts or replaces a soundboard clip of the server
+func (d *db) AddSound(guildID string, sound store.Sound) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec(
+		`INSERT OR REPLACE INTO sounds(guildID, name, file, uploader, duration)
+		VALUES (?,?,?,?,?)`,
+		guildID, sound.Name, sound.File, sound.Uploader, int64(sound.Duration),
+	)
+
+	return err
+}
+
+// GetSound gets the named soundboard clip of the server, returning sql.ErrNoRows if it does not
+// exist
+func (d *db) GetSound(guildID, name string) (store.Sound, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	sound := store.Sound{Name: name}
+	var duration int64
+	err := d.ctx.QueryRow(
+		"SELECT file, uploader, duration FROM sounds WHERE guildID = ? AND name = ?",
+		guildID, name).Scan(&sound.File, &sound.Uploader, &duration)
+	if err != nil {
+		return store.Sound{}, err
+	}
+	sound.Duration = time.Duration(duration)
+
+	return sound, nil
+}
+
+// GetSounds gets every soundboard clip of the server, ordered by name
+func (d *db) GetSounds(guildID string) ([]store.Sound, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	rows, err := d.ctx.Query(
+		"SELECT name, file, uploader, duration FROM sounds WHERE guildID = ? ORDER BY name",
+		guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}(rows)
+
+	var sounds []store.Sound
+	for rows.Next() {
+		var sound store.Sound
+		var duration int64
+		err := rows.Scan(&sound.Name, &sound.File, &sound.Uploader, &duration)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		sound.Duration = time.Duration(duration)
+		sounds = append(sounds, sound)
+	}
+
+	return sounds, nil
+}
+
+// DeleteSound removes the named soundboard clip of the server
+func (d *db) DeleteSound(guildID, name string) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec("DELETE FROM sounds WHERE guildID = ? AND name = ?", guildID, name)
+	return err
+}
+
+// AddPlay records that a track has started playing in a guild, returning the ID of the record
+func (d *db) AddPlay(guildID string, play store.Play) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+	res, err := d.ctx.Exec(
+		`INSERT INTO plays(guildID, source, trackID, title, duration, requester, started, ended,
+			skipped) VALUES (?,?,?,?,?,?,?,?,?)`,
+		guildID, play.Track.Source, play.Track.ID, play.Track.Title, int64(play.Track.Duration),
+		play.Track.Requester, play.Started.Unix(), unixOrZero(play.Ended), play.Skipped,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// FinishPlay records when a track stopped playing and whether it was skipped
+func (d *db) FinishPlay(playID int64, ended time.Time, skipped bool) error {
+	d.Lock()
+	defer d.Unlock()
+	_, err := d.ctx.Exec("UPDATE plays SET ended = ?, skipped = ? WHERE id = ?",
+		unixOrZero(ended), skipped, playID)
+	return err
+}
+
+// GetHistory gets the most recently played tracks in a guild, most recent first
+func (d *db) GetHistory(guildID string, limit int) ([]store.Play, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	rows, err := d.ctx.Query(
+		`SELECT source, trackID, title, duration, requester, started, ended, skipped FROM plays
+		WHERE guildID = ? ORDER BY started DESC, id DESC LIMIT ?`, guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}(rows)
+
+	var plays []store.Play
+	for rows.Next() {
+		var p store.Play
+		var duration, started, ended int64
+		err := rows.Scan(&p.Track.Source, &p.Track.ID, &p.Track.Title, &duration,
+			&p.Track.Requester, &started, &ended, &p.Skipped)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		p.Track.Duration = time.Duration(duration)
+		p.Started = time.Unix(started, 0)
+		if ended != 0 {
+			p.Ended = time.Unix(ended, 0)
+		}
+		plays = append(plays, p)
+	}
+
+	return plays, nil
+}
+
+// GetTopTracks gets the most played tracks in a guild, most played first
+func (d *db) GetTopTracks(guildID string, limit int) ([]store.TrackPlays, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	rows, err := d.ctx.Query(
+		`SELECT source, trackID, MAX(title), MAX(duration), COUNT(*) AS n FROM plays
+		WHERE guildID = ? GROUP BY source, trackID ORDER BY n DESC, MAX(id) DESC LIMIT ?`,
+		guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}(rows)
+
+	var tracks []store.TrackPlays
+	for rows.Next() {
+		var t store.TrackPlays
+		var duration int64
+		err := rows.Scan(&t.Track.Source, &t.Track.ID, &t.Track.Title, &duration, &t.Plays)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		t.Track.Duration = time.Duration(duration)
+		tracks = append(tracks, t)
+	}
+
+	return tracks, nil
+}
+
+// GetTopRequesters gets the users whose requests have been played most in a guild, most played
+// first
+func (d *db) GetTopRequesters(guildID string, limit int) ([]store.RequesterPlays, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	rows, err := d.ctx.Query(
+		`SELECT requester, COUNT(*) AS n FROM plays WHERE guildID = ? AND requester != ''
+		GROUP BY requester ORDER BY n DESC LIMIT ?`, guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}(rows)
+
+	var requesters []store.RequesterPlays
+	for rows.Next() {
+		var r store.RequesterPlays
+		if err := rows.Scan(&r.Requester, &r.Plays); err != nil {
+			log.Error().Err(err).Msg("")
+			continue
+		}
+		requesters = append(requesters, r)
+	}
+
+	return requesters, nil
+}
+
+// unixOrZero returns the Unix time of t, or zero for the zero time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// joinList stores a list of names or IDs, none of which contain commas, in a single column
+func joinList(list []string) string {
+	return strings.Join(list, ",")
+}
+
+// splitList returns the list stored by joinList
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
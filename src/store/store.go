@@ -1,5 +1,7 @@
 package store
 
+import "time"
+
 // Store represents a server database
 type Store interface {
 	AddOrUpdateCommand(guildID, commandName, commandText string) error
@@ -15,4 +17,149 @@ type Store interface {
 
 	SetName(guildID, name string) error
 	GetName(guildID string) (string, error)
+
+	SetSkipSettings(guildID string, settings SkipSettings) error
+	GetSkipSettings(guildID string) (SkipSettings, error)
+
+	SetAudioSettings(guildID string, settings AudioSettings) error
+	GetAudioSettings(guildID string) (AudioSettings, error)
+
+	SetIdleSettings(guildID string, settings IdleSettings) error
+	GetIdleSettings(guildID string) (IdleSettings, error)
+
+	SetQueuePolicy(guildID string, policy QueuePolicy) error
+	GetQueuePolicy(guildID string) (QueuePolicy, error)
+
+	SetAnnounce(guildID string, announce bool) error
+	GetAnnounce(guildID string) (bool, error)
+
+	SaveQueue(guildID string, queue SavedQueue) error
+	GetQueue(guildID string) (SavedQueue, error)
+	GetAllQueues() (map[string]SavedQueue, error)
+	DeleteQueue(guildID string) error
+
+	SavePlaylist(ownerID, name string, tracks []TrackRef) error
+	AddToPlaylist(ownerID, name string, tracks []TrackRef) error
+	GetPlaylist(ownerID, name string) ([]TrackRef, error)
+	GetPlaylists(ownerID string) ([]string, error)
+	DeletePlaylist(ownerID, name string) error
+
+	AddSound(guildID string, sound Sound) error
+	GetSound(guildID, name string) (Sound, error)
+	GetSounds(guildID string) ([]Sound, error)
+	DeleteSound(guildID, name string) error
+
+	AddPlay(guildID string, play Play) (int64, error)
+	FinishPlay(playID int64, ended time.Time, skipped bool) error
+	GetHistory(guildID string, limit int) ([]Play, error)
+	GetTopTracks(guildID string, limit int) ([]TrackPlays, error)
+	GetTopRequesters(guildID string, limit int) ([]RequesterPlays, error)
+}
+
+// TrackRef identifies a track by the media source which can resolve it, along with enough
+// information to display it without resolving it.
+type TrackRef struct {
+	Source    string
+	ID        string
+	Title     string
+	Duration  time.Duration
+	Requester string
+}
+
+// SkipSettings determine how songs are skipped in a guild.
+type SkipSettings struct {
+	// Vote is true if users who did not request a song must vote to skip it.
+	Vote bool
+	// Threshold is the fraction of listeners in the voice channel who must vote to skip a song.
+	Threshold float64
+}
+
+// AudioSettings determine how audio is played in a guild.
+type AudioSettings struct {
+	// Volume is the playback volume as a percentage of the original volume.
+	Volume int
+	// Normalize is true if the loudness of songs is normalized.
+	Normalize bool
+}
+
+// IdleSettings determine when the player leaves a voice channel in a guild.
+type IdleSettings struct {
+	// QueueTimeout is how long the player waits for a new song once the queue is empty.
+	QueueTimeout time.Duration
+	// AloneTimeout is how long the player waits for someone to rejoin the voice channel once
+	// everyone else has left.
+	AloneTimeout time.Duration
+}
+
+// QueuePolicy limits the songs which can be added to a guild's queue. Zero limits and empty lists
+// impose no restriction.
+type QueuePolicy struct {
+	// MaxTrackLength is the longest song which can be added to the queue.
+	MaxTrackLength time.Duration
+	// MaxUserTracks is the most songs each user can have waiting in the queue at once.
+	MaxUserTracks int
+	// MaxQueueDuration is the longest the songs waiting in the queue can take to play in total.
+	MaxQueueDuration time.Duration
+	// AllowedSources, if not empty, holds the only media sources songs can come from, and
+	// DeniedSources holds sources songs cannot come from.
+	AllowedSources []string
+	DeniedSources  []string
+	// AllowedChannels, if not empty, holds the only voice channels songs can be requested from,
+	// and DeniedChannels holds channels songs cannot be requested from.
+	AllowedChannels []string
+	DeniedChannels  []string
+}
+
+// SavedQueue is the state of a guild's song queue at the time its player shut down.
+type SavedQueue struct {
+	ChannelID string
+	// Tracks holds the song which was playing, if any, followed by the songs waiting in the queue.
+	Tracks []TrackRef
+	// Position is how far into the first track playback had reached.
+	Position time.Duration
+	// Reason is why the player shut down.
+	Reason SaveReason
+}
+
+// SaveReason is why a guild's player shut down and saved its queue.
+type SaveReason int
+
+const (
+	// SaveShutdown means the bot was shutting down.
+	SaveShutdown SaveReason = iota
+	// SaveIdle means the player left after being idle, or could not join its channel.
+	SaveIdle
+	// SaveDisconnect means a user told the player to leave.
+	SaveDisconnect
+)
+
+// Sound is a soundboard clip belonging to a guild.
+type Sound struct {
+	Name string
+	// File is the path of the clip, relative to the soundboard directory.
+	File     string
+	Uploader string
+	Duration time.Duration
+}
+
+// Play is a record of a track which was played in a guild.
+type Play struct {
+	Track   TrackRef
+	Started time.Time
+	// Ended is the zero time if the track is still playing, or if the player stopped without
+	// recording its end.
+	Ended   time.Time
+	Skipped bool
+}
+
+// TrackPlays is the number of times a track has been played in a guild.
+type TrackPlays struct {
+	Track TrackRef
+	Plays int
+}
+
+// RequesterPlays is the number of tracks requested by a user which have been played in a guild.
+type RequesterPlays struct {
+	Requester string
+	Plays     int
 }